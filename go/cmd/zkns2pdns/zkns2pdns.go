@@ -0,0 +1,99 @@
+// zkns2pdns is a PowerDNS pipe backend
+// (https://doc.powerdns.com/authoritative/backends/pipe.html) that
+// answers queries from zkns data stored in ZooKeeper. It is normally
+// launched by pdns_server itself, talking to it over stdin/stdout.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/youtube/vitess/go/netutil"
+	"github.com/youtube/vitess/go/zk/gozkconn"
+	"github.com/youtube/vitess/go/zk/samuelzkconn"
+	"github.com/youtube/vitess/go/zk/zkconn"
+	"github.com/youtube/vitess/go/zk/zkns/pdns"
+)
+
+var (
+	zkAddr    = flag.String("zk_addr", "localhost:2181", "comma separated list of zookeeper servers")
+	zkImpl    = flag.String("zk_impl", "gozk", "zookeeper client implementation to use: gozk or samuel")
+	zkRoot    = flag.String("zk_root", "/zk/local/zkns", "zkns root to serve")
+	dnsSuffix = flag.String("dns_suffix", ".zkns.local.zk", "DNS suffix appended to zkns paths")
+
+	soaRefresh = flag.Int("soa_refresh", 1800, "SOA refresh interval, in seconds")
+	soaRetry   = flag.Int("soa_retry", 600, "SOA retry interval, in seconds")
+	soaExpire  = flag.Int("soa_expire", 3600, "SOA expire interval, in seconds")
+	soaMinimum = flag.Int("soa_minimum", 300, "SOA minimum TTL, in seconds")
+
+	strict = flag.Bool("strict", false, "force every query to sync with zookeeper before answering, instead of trusting the watch-backed cache")
+
+	dnssec       = flag.Bool("dnssec", false, "sign answers with the zone's DNSSEC keys")
+	dnssecKeyDir = flag.String("dnssec_key_dir", "", "directory holding <zone>.ksk and <zone>.zsk key files; if empty, keys are read from ZooKeeper instead")
+	dnssecZkRoot = flag.String("dnssec_zk_root", "/zk/local/zkns/_dnssec", "ZooKeeper root holding <zone>/ksk and <zone>/zsk keys, when -dnssec_key_dir isn't set")
+)
+
+// splitZkAddr splits a -zk_addr flag value into its comma separated
+// host:port servers. gozkconn.Dial takes the raw comma-separated string
+// and splits it itself, but samuelzkconn.Dial wants the servers
+// pre-split.
+func splitZkAddr(addr string) []string {
+	return strings.Split(addr, ",")
+}
+
+func dialZk(impl, addr string) (zkconn.Conn, error) {
+	switch impl {
+	case "gozk":
+		conn, _, err := gozkconn.Dial(addr, 30*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	case "samuel":
+		conn, _, err := samuelzkconn.Dial(splitZkAddr(addr), 30*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	default:
+		log.Fatalf("zkns2pdns: unknown -zk_impl %q, want gozk or samuel", impl)
+		panic("unreachable")
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	zconn, err := dialZk(*zkImpl, *zkAddr)
+	if err != nil {
+		log.Fatalf("zkns2pdns: can't connect to zookeeper: %v", err)
+	}
+
+	fqdn := netutil.FullyQualifiedHostnameOrPanic()
+	pd := pdns.NewServer(zconn, fqdn, *dnsSuffix, *zkRoot)
+	pd.SetSOAParams(*soaRefresh, *soaRetry, *soaExpire, *soaMinimum)
+	pd.SetStrict(*strict)
+
+	if *dnssec {
+		zone, err := loadDnssecZone(zconn)
+		if err != nil {
+			log.Fatalf("zkns2pdns: can't load dnssec keys: %v", err)
+		}
+		pd.SetDnssec(zone)
+	}
+
+	pd.Serve(os.Stdin, os.Stdout)
+}
+
+// loadDnssecZone loads the signing keys for -dns_suffix, from
+// -dnssec_key_dir if set or from ZooKeeper otherwise.
+func loadDnssecZone(zconn zkconn.Conn) (*pdns.DnssecZone, error) {
+	zone := strings.TrimPrefix(*dnsSuffix, ".")
+	if *dnssecKeyDir != "" {
+		return pdns.LoadDnssecZoneFromDir(*dnssecKeyDir, zone)
+	}
+	return pdns.LoadDnssecZoneFromZk(zconn, *dnssecZkRoot, zone)
+}
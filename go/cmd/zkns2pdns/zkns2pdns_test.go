@@ -0,0 +1,21 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitZkAddr(t *testing.T) {
+	cases := []struct {
+		addr string
+		want []string
+	}{
+		{"localhost:2181", []string{"localhost:2181"}},
+		{"zk1:2181,zk2:2181,zk3:2181", []string{"zk1:2181", "zk2:2181", "zk3:2181"}},
+	}
+	for _, c := range cases {
+		if got := splitZkAddr(c.addr); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitZkAddr(%q) = %v, want %v", c.addr, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// +build zkconformance
+
+// This file only builds with `go test -tags zkconformance`, since it
+// dials a real ZooKeeper ensemble. Point -zk_addr at one (e.g. a
+// locally running `zkServer.sh start`) to check that fakezk.Conn agrees
+// with a real zk/samuelzkconn.Conn on the handful of behaviors Vitess
+// actually depends on: error codes, watch delivery and version
+// bookkeeping.
+package fakezk_test
+
+import (
+	"flag"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/zk/fakezk"
+	"github.com/youtube/vitess/go/zk/samuelzkconn"
+	"github.com/youtube/vitess/go/zk/zkconn"
+)
+
+var zkAddr = flag.String("zk_addr", "localhost:2181", "zookeeper server to compare fakezk against")
+
+// conformanceScenario runs the same sequence of Conn calls against conn,
+// rooted at root (which must already exist), failing t if conn doesn't
+// behave the way Vitess code expects any zkconn.Conn to behave.
+func conformanceScenario(t *testing.T, conn zkconn.Conn, root string) {
+	child := root + "/child"
+
+	if _, _, err := conn.Get(child); zkconn.Code(err) != zkconn.ErrNoNode {
+		t.Fatalf("Get on a missing node: got %v, want ErrNoNode", err)
+	}
+
+	_, existsWatch, err := conn.ExistsW(child)
+	if err != nil {
+		t.Fatalf("ExistsW failed: %v", err)
+	}
+
+	if _, err := conn.Create(child, "v1", 0, nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	select {
+	case ev := <-existsWatch:
+		if ev.Type != zkconn.EventNodeCreated {
+			t.Fatalf("ExistsW fired with %v, want EventNodeCreated", ev.Type)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ExistsW never fired after Create")
+	}
+
+	data, stat, getWatch, err := conn.GetW(child)
+	if err != nil || data != "v1" {
+		t.Fatalf("GetW after Create: got %v, %v, want v1", data, err)
+	}
+
+	if _, err := conn.Set(child, "v2", stat.Version()); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	select {
+	case ev := <-getWatch:
+		if ev.Type != zkconn.EventNodeDataChanged {
+			t.Fatalf("GetW watch fired with %v, want EventNodeDataChanged", ev.Type)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("GetW watch never fired after Set")
+	}
+
+	if _, stat, err = conn.Get(child); err != nil {
+		t.Fatalf("Get after Set failed: %v", err)
+	}
+	if _, err := conn.Set(child, "v3", stat.Version()+1); zkconn.Code(err) != zkconn.ErrBadVersion {
+		t.Fatalf("Set with a stale version: got %v, want ErrBadVersion", err)
+	}
+
+	children, _, childWatch, err := conn.ChildrenW(root)
+	if err != nil || len(children) != 1 {
+		t.Fatalf("ChildrenW: got %v, %v, want exactly [child]", children, err)
+	}
+
+	if _, stat, err = conn.Get(child); err != nil {
+		t.Fatalf("Get before Delete failed: %v", err)
+	}
+	if err := conn.Delete(child, stat.Version()); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	select {
+	case ev := <-childWatch:
+		if ev.Type != zkconn.EventNodeChildrenChanged {
+			t.Fatalf("ChildrenW watch fired with %v, want EventNodeChildrenChanged", ev.Type)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ChildrenW watch never fired after Delete")
+	}
+
+	if _, _, err := conn.Get(child); zkconn.Code(err) != zkconn.ErrNoNode {
+		t.Fatalf("Get after Delete: got %v, want ErrNoNode", err)
+	}
+}
+
+func TestFakeZkMatchesRealZk(t *testing.T) {
+	real, _, err := samuelzkconn.Dial([]string{*zkAddr}, 5*time.Second)
+	if err != nil {
+		t.Skipf("no zookeeper at %v: %v", *zkAddr, err)
+	}
+	defer real.Close()
+
+	root := fmt.Sprintf("/fakezk_conformance_%d", time.Now().UnixNano())
+	if _, err := real.Create(root, "", 0, nil); err != nil {
+		t.Fatalf("can't create conformance root %v: %v", root, err)
+	}
+	defer real.Delete(root, -1)
+
+	t.Run("fakezk", func(t *testing.T) {
+		conformanceScenario(t, fakezk.NewConn(map[string]string{"/conformance": ""}), "/conformance")
+	})
+	t.Run("realzk", func(t *testing.T) {
+		conformanceScenario(t, real, root)
+	})
+}
@@ -0,0 +1,458 @@
+// Package fakezk provides an in-process fake of zk/zkconn.Conn, backed
+// by a goroutine-safe tree of znodes rather than a real ZooKeeper
+// ensemble. It exists so that packages which only need a Conn to test
+// against (pdns, the tablet manager, the topology server, ...) don't
+// each have to grow their own ad-hoc fake.
+package fakezk
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/youtube/vitess/go/zk/zkconn"
+)
+
+// node is one znode in the fake tree.
+type node struct {
+	data     string
+	acl      []zkconn.ACL
+	children map[string]*node
+
+	ephemeral bool
+	seq       int64 // next sequence suffix to hand out to a child
+
+	czxid, mzxid, pzxid          int64
+	ctime, mtime                 time.Time
+	version, cversion, aversion  int
+	ephemeralOwner               int64
+}
+
+// stat is an immutable snapshot of a node's metadata, implementing
+// zkconn.Stat.
+type stat struct {
+	czxid, mzxid, pzxid         int64
+	ctime, mtime                time.Time
+	version, cversion, aversion int
+	ephemeralOwner              int64
+	dataLength                  int
+	numChildren                 int
+}
+
+func (s *stat) Czxid() int64          { return s.czxid }
+func (s *stat) Mzxid() int64          { return s.mzxid }
+func (s *stat) CTime() time.Time      { return s.ctime }
+func (s *stat) MTime() time.Time      { return s.mtime }
+func (s *stat) Version() int          { return s.version }
+func (s *stat) CVersion() int         { return s.cversion }
+func (s *stat) AVersion() int         { return s.aversion }
+func (s *stat) EphemeralOwner() int64 { return s.ephemeralOwner }
+func (s *stat) DataLength() int       { return s.dataLength }
+func (s *stat) NumChildren() int      { return s.numChildren }
+func (s *stat) Pzxid() int64          { return s.pzxid }
+
+func snapshot(n *node) *stat {
+	return &stat{
+		czxid: n.czxid, mzxid: n.mzxid, pzxid: n.pzxid,
+		ctime: n.ctime, mtime: n.mtime,
+		version: n.version, cversion: n.cversion, aversion: n.aversion,
+		ephemeralOwner: n.ephemeralOwner,
+		dataLength:     len(n.data),
+		numChildren:    len(n.children),
+	}
+}
+
+// Conn is a fake zkconn.Conn backed by an in-memory znode tree.
+type Conn struct {
+	mu           sync.Mutex
+	root         *node
+	nextZxid     int64
+	watches      map[string][]chan zkconn.Event
+	childWatches map[string][]chan zkconn.Event
+}
+
+// NewConn returns a fake Conn pre-populated with one znode per
+// path/value pair in data. Every ancestor directory a path implies
+// (e.g. "/zk/test" for "/zk/test/zkns") is created automatically, with
+// empty data, so callers can seed deep paths directly as the original
+// TestZkConn allowed.
+func NewConn(data map[string]string) *Conn {
+	c := &Conn{
+		root: &node{children: make(map[string]*node)},
+	}
+	paths := make([]string, 0, len(data))
+	for p := range data {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		c.seedLocked(p, data[p])
+	}
+	return c
+}
+
+func split(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// lookupLocked returns the node at path, or nil if it (or an ancestor)
+// doesn't exist. c.mu must already be held.
+func (c *Conn) lookupLocked(path string) *node {
+	n := c.root
+	for _, seg := range split(path) {
+		child, ok := n.children[seg]
+		if !ok {
+			return nil
+		}
+		n = child
+	}
+	return n
+}
+
+// parentLocked returns path's immediate parent node and path's own
+// last segment. c.mu must already be held.
+func (c *Conn) parentLocked(path string) (parent *node, name string, ok bool) {
+	segs := split(path)
+	if len(segs) == 0 {
+		return nil, "", false
+	}
+	parentPath := "/" + strings.Join(segs[:len(segs)-1], "/")
+	parent = c.lookupLocked(parentPath)
+	return parent, segs[len(segs)-1], parent != nil
+}
+
+// seedLocked creates path (and any missing ancestor directories) with
+// value, bypassing the parent-must-exist check NewConn's callers
+// shouldn't have to satisfy. c.mu doesn't need to be held yet, since
+// NewConn runs before the Conn is visible to anyone else.
+func (c *Conn) seedLocked(path, value string) {
+	segs := split(path)
+	n := c.root
+	for i, seg := range segs {
+		child, ok := n.children[seg]
+		if !ok {
+			now := time.Now()
+			zxid := c.allocZxidLocked()
+			child = &node{children: make(map[string]*node), czxid: zxid, mzxid: zxid, pzxid: zxid, ctime: now, mtime: now}
+			n.children[seg] = child
+		}
+		if i == len(segs)-1 {
+			child.data = value
+		}
+		n = child
+	}
+}
+
+func (c *Conn) allocZxidLocked() int64 {
+	c.nextZxid++
+	return c.nextZxid
+}
+
+func (c *Conn) Get(path string) (data string, s zkconn.Stat, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.lookupLocked(path)
+	if n == nil {
+		return "", nil, &zkconn.Error{Op: "Get", Code: zkconn.ErrNoNode, Path: path}
+	}
+	return n.data, snapshot(n), nil
+}
+
+// GetW behaves like Get, additionally registering a watch that fires
+// the next time Set or Delete touches path.
+func (c *Conn) GetW(path string) (data string, s zkconn.Stat, watch <-chan zkconn.Event, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.lookupLocked(path)
+	if n == nil {
+		return "", nil, nil, &zkconn.Error{Op: "GetW", Code: zkconn.ErrNoNode, Path: path}
+	}
+	return n.data, snapshot(n), c.addWatchLocked(path), nil
+}
+
+func sortedChildren(n *node) []string {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Conn) Children(path string) (children []string, s zkconn.Stat, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.lookupLocked(path)
+	if n == nil {
+		return nil, nil, &zkconn.Error{Op: "Children", Code: zkconn.ErrNoNode, Path: path}
+	}
+	return sortedChildren(n), snapshot(n), nil
+}
+
+// ChildrenW behaves like Children, additionally registering a watch
+// that fires the next time Create or Delete adds or removes a direct
+// child of path.
+func (c *Conn) ChildrenW(path string) (children []string, s zkconn.Stat, watch <-chan zkconn.Event, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.lookupLocked(path)
+	if n == nil {
+		return nil, nil, nil, &zkconn.Error{Op: "ChildrenW", Code: zkconn.ErrNoNode, Path: path}
+	}
+	return sortedChildren(n), snapshot(n), c.addChildWatchLocked(path), nil
+}
+
+func (c *Conn) Exists(path string) (s zkconn.Stat, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := c.lookupLocked(path); n != nil {
+		return snapshot(n), nil
+	}
+	return nil, nil
+}
+
+// ExistsW behaves like Exists, additionally registering a watch that
+// fires the next time Create, Set or Delete touches path.
+func (c *Conn) ExistsW(path string) (s zkconn.Stat, watch <-chan zkconn.Event, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	watch = c.addWatchLocked(path)
+	if n := c.lookupLocked(path); n != nil {
+		return snapshot(n), watch, nil
+	}
+	return nil, watch, nil
+}
+
+func (c *Conn) Create(path, value string, flags int, aclv []zkconn.ACL) (pathCreated string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lookupLocked(path) != nil {
+		return "", &zkconn.Error{Op: "Create", Code: zkconn.ErrNodeExists, Path: path}
+	}
+	parent, name, ok := c.parentLocked(path)
+	if !ok {
+		return "", &zkconn.Error{Op: "Create", Code: zkconn.ErrNoNode, Path: path}
+	}
+
+	actualName := name
+	if flags&zkconn.FlagSequence != 0 {
+		actualName = fmt.Sprintf("%s%010d", name, parent.seq)
+		parent.seq++
+	}
+
+	now := time.Now()
+	zxid := c.allocZxidLocked()
+	child := &node{
+		data:      value,
+		acl:       aclv,
+		children:  make(map[string]*node),
+		ephemeral: flags&zkconn.FlagEphemeral != 0,
+		czxid:     zxid, mzxid: zxid, pzxid: zxid,
+		ctime: now, mtime: now,
+	}
+	parent.children[actualName] = child
+	parent.cversion++
+	parent.pzxid = zxid
+
+	actualPath := joinPath(dirOf(path), actualName)
+	c.fireLocked(actualPath, zkconn.EventNodeCreated)
+	return actualPath, nil
+}
+
+// dirOf returns path's parent directory, without requiring the node to
+// exist (unlike parentLocked, which needs the tree).
+func dirOf(path string) string {
+	if idx := strings.LastIndex(path, "/"); idx > 0 {
+		return path[:idx]
+	}
+	return "/"
+}
+
+// joinPath appends name as a child of dir, avoiding a doubled slash
+// when dir is the root.
+func joinPath(dir, name string) string {
+	if dir == "/" {
+		return "/" + name
+	}
+	return dir + "/" + name
+}
+
+func (c *Conn) Set(path, value string, version int) (s zkconn.Stat, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.lookupLocked(path)
+	if n == nil {
+		return nil, &zkconn.Error{Op: "Set", Code: zkconn.ErrNoNode, Path: path}
+	}
+	if version >= 0 && version != n.version {
+		return nil, &zkconn.Error{Op: "Set", Code: zkconn.ErrBadVersion, Path: path}
+	}
+	n.data = value
+	n.version++
+	n.mzxid = c.allocZxidLocked()
+	n.mtime = time.Now()
+	c.fireLocked(path, zkconn.EventNodeDataChanged)
+	return snapshot(n), nil
+}
+
+func (c *Conn) Delete(path string, version int) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.lookupLocked(path)
+	if n == nil {
+		return &zkconn.Error{Op: "Delete", Code: zkconn.ErrNoNode, Path: path}
+	}
+	if version >= 0 && version != n.version {
+		return &zkconn.Error{Op: "Delete", Code: zkconn.ErrBadVersion, Path: path}
+	}
+	if len(n.children) > 0 {
+		return &zkconn.Error{Op: "Delete", Code: zkconn.ErrNotEmpty, Path: path}
+	}
+	parent, name, ok := c.parentLocked(path)
+	if !ok {
+		return &zkconn.Error{Op: "Delete", Code: zkconn.ErrNoNode, Path: path}
+	}
+	delete(parent.children, name)
+	parent.cversion++
+	parent.pzxid = c.allocZxidLocked()
+	c.fireLocked(path, zkconn.EventNodeDeleted)
+	return nil
+}
+
+// Close removes every ephemeral znode in the tree and fires delete
+// watches for each one, mirroring how a real ZooKeeper session drops its
+// ephemeral nodes when it ends. There's no separate session concept
+// here: a fake Conn only ever owns one tree, so "the session closing"
+// and "this Conn closing" are the same event.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleteEphemeralLocked("/", c.root)
+	return nil
+}
+
+// deleteEphemeralLocked removes every ephemeral descendant of n, found
+// at path, firing a delete watch for each removal. c.mu must already be
+// held.
+func (c *Conn) deleteEphemeralLocked(path string, n *node) {
+	for name, child := range n.children {
+		childPath := joinPath(path, name)
+		if child.ephemeral {
+			delete(n.children, name)
+			n.cversion++
+			n.pzxid = c.allocZxidLocked()
+			c.fireLocked(childPath, zkconn.EventNodeDeleted)
+			continue
+		}
+		c.deleteEphemeralLocked(childPath, child)
+	}
+}
+
+// Sync is a no-op: every Conn method already locks c.mu before touching
+// the tree, so there's no asynchronous replication for a caller to wait
+// out the way there would be against a real ZooKeeper ensemble.
+func (c *Conn) Sync(path string) (string, error) {
+	return path, nil
+}
+
+// RetryChange applies changeFunc to path, retrying if a concurrent
+// writer changes path's version out from under it.
+func (c *Conn) RetryChange(path string, flags int, aclv []zkconn.ACL, changeFunc zkconn.ChangeFunc) error {
+	for {
+		oldValue, s, err := c.Get(path)
+		if err != nil && zkconn.Code(err) != zkconn.ErrNoNode {
+			return err
+		}
+		newValue, err := changeFunc(oldValue, s)
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			_, err = c.Create(path, newValue, flags, aclv)
+		} else {
+			_, err = c.Set(path, newValue, s.Version())
+		}
+		if err == nil {
+			return nil
+		}
+		if zkconn.Code(err) != zkconn.ErrBadVersion && zkconn.Code(err) != zkconn.ErrNodeExists {
+			return err
+		}
+		// Lost the race with another writer; retry against the new version.
+	}
+}
+
+func (c *Conn) ACL(path string) (aclv []zkconn.ACL, s zkconn.Stat, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.lookupLocked(path)
+	if n == nil {
+		return nil, nil, &zkconn.Error{Op: "ACL", Code: zkconn.ErrNoNode, Path: path}
+	}
+	return n.acl, snapshot(n), nil
+}
+
+func (c *Conn) SetACL(path string, aclv []zkconn.ACL, version int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.lookupLocked(path)
+	if n == nil {
+		return &zkconn.Error{Op: "SetACL", Code: zkconn.ErrNoNode, Path: path}
+	}
+	if version >= 0 && version != n.aversion {
+		return &zkconn.Error{Op: "SetACL", Code: zkconn.ErrBadVersion, Path: path}
+	}
+	n.acl = aclv
+	n.aversion++
+	return nil
+}
+
+// addWatchLocked registers a one-shot watch on path. c.mu must already
+// be held.
+func (c *Conn) addWatchLocked(path string) <-chan zkconn.Event {
+	if c.watches == nil {
+		c.watches = make(map[string][]chan zkconn.Event)
+	}
+	ch := make(chan zkconn.Event, 1)
+	c.watches[path] = append(c.watches[path], ch)
+	return ch
+}
+
+// addChildWatchLocked registers a one-shot children watch on path.
+// c.mu must already be held.
+func (c *Conn) addChildWatchLocked(path string) <-chan zkconn.Event {
+	if c.childWatches == nil {
+		c.childWatches = make(map[string][]chan zkconn.Event)
+	}
+	ch := make(chan zkconn.Event, 1)
+	c.childWatches[path] = append(c.childWatches[path], ch)
+	return ch
+}
+
+// fireLocked fires and clears every watch on path, plus, when path was
+// created or deleted, every children watch on path's parent directory.
+// c.mu must already be held.
+func (c *Conn) fireLocked(path string, eventType zkconn.EventType) {
+	for _, ch := range c.watches[path] {
+		ch <- zkconn.Event{Type: eventType, Path: path}
+		close(ch)
+	}
+	delete(c.watches, path)
+
+	if eventType != zkconn.EventNodeCreated && eventType != zkconn.EventNodeDeleted {
+		return
+	}
+
+	parent := dirOf(path)
+	for _, ch := range c.childWatches[parent] {
+		ch <- zkconn.Event{Type: zkconn.EventNodeChildrenChanged, Path: parent}
+		close(ch)
+	}
+	delete(c.childWatches, parent)
+}
@@ -0,0 +1,28 @@
+package fakezk
+
+import (
+	"testing"
+
+	"github.com/youtube/vitess/go/zk/zkconn"
+)
+
+func TestCloseRemovesEphemeralNodes(t *testing.T) {
+	c := NewConn(map[string]string{"/zk/test": ""})
+	if _, err := c.Create("/zk/test/persistent", "", 0, nil); err != nil {
+		t.Fatalf("Create(persistent): %v", err)
+	}
+	if _, err := c.Create("/zk/test/ephemeral", "", zkconn.FlagEphemeral, nil); err != nil {
+		t.Fatalf("Create(ephemeral): %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if s, err := c.Exists("/zk/test/ephemeral"); err != nil || s != nil {
+		t.Fatalf("Exists(ephemeral) after Close = %v, %v, want nil, nil", s, err)
+	}
+	if s, err := c.Exists("/zk/test/persistent"); err != nil || s == nil {
+		t.Fatalf("Exists(persistent) after Close = %v, %v, want it to survive", s, err)
+	}
+}
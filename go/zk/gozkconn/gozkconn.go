@@ -0,0 +1,212 @@
+// Package gozkconn implements zk/zkconn.Conn on top of the old
+// launchpad.net/gozk/zookeeper C bindings. It exists so that hosts with
+// the native ZooKeeper client library installed can keep using it; hosts
+// that can't link against libzookeeper_mt should use zk/samuelzkconn
+// instead.
+package gozkconn
+
+import (
+	"time"
+
+	"github.com/youtube/vitess/go/zk/zkconn"
+	"launchpad.net/gozk/zookeeper"
+)
+
+// Conn wraps a *zookeeper.Conn to implement zkconn.Conn.
+type Conn struct {
+	conn *zookeeper.Conn
+}
+
+// Dial connects to the ZooKeeper cell described by addr (a
+// comma-separated host:port list), waiting up to connectTimeout for the
+// initial session event.
+func Dial(addr string, connectTimeout time.Duration) (*Conn, <-chan zookeeper.Event, error) {
+	zconn, session, err := zookeeper.Dial(addr, connectTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Conn{conn: zconn}, session, nil
+}
+
+func toACL(aclv []zkconn.ACL) []zookeeper.ACL {
+	result := make([]zookeeper.ACL, len(aclv))
+	for i, a := range aclv {
+		result[i] = zookeeper.ACL{Perms: a.Perms, Scheme: a.Scheme, Id: a.ID}
+	}
+	return result
+}
+
+func fromACL(aclv []zookeeper.ACL) []zkconn.ACL {
+	result := make([]zkconn.ACL, len(aclv))
+	for i, a := range aclv {
+		result[i] = zkconn.ACL{Perms: a.Perms, Scheme: a.Scheme, ID: a.Id}
+	}
+	return result
+}
+
+func fromError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if zkErr, ok := err.(*zookeeper.Error); ok {
+		return &zkconn.Error{Op: op, Code: zkconn.ErrorCode(zkErr.Code), Path: path}
+	}
+	return err
+}
+
+func fromEventType(t zookeeper.EventType) zkconn.EventType {
+	switch t {
+	case zookeeper.EVENT_CREATED:
+		return zkconn.EventNodeCreated
+	case zookeeper.EVENT_DELETED:
+		return zkconn.EventNodeDeleted
+	case zookeeper.EVENT_CHANGED:
+		return zkconn.EventNodeDataChanged
+	case zookeeper.EVENT_CHILD:
+		return zkconn.EventNodeChildrenChanged
+	case zookeeper.EVENT_SESSION:
+		return zkconn.EventSession
+	default:
+		return zkconn.EventNone
+	}
+}
+
+func fromState(s zookeeper.State) zkconn.StateType {
+	switch s {
+	case zookeeper.STATE_CONNECTING, zookeeper.STATE_ASSOCIATING:
+		return zkconn.StateConnecting
+	case zookeeper.STATE_CONNECTED:
+		return zkconn.StateConnected
+	case zookeeper.STATE_EXPIRED_SESSION:
+		return zkconn.StateExpired
+	default:
+		return zkconn.StateUnknown
+	}
+}
+
+func fromEvent(e zookeeper.Event) zkconn.Event {
+	return zkconn.Event{
+		Type:  fromEventType(e.Type),
+		State: fromState(e.State),
+		Path:  e.Path,
+	}
+}
+
+func watchChan(in <-chan zookeeper.Event) <-chan zkconn.Event {
+	out := make(chan zkconn.Event, 1)
+	go func() {
+		for e := range in {
+			out <- fromEvent(e)
+		}
+		close(out)
+	}()
+	return out
+}
+
+func (c *Conn) Get(path string) (string, zkconn.Stat, error) {
+	data, stat, err := c.conn.Get(path)
+	if err != nil {
+		return "", nil, fromError("Get", path, err)
+	}
+	return data, stat, nil
+}
+
+func (c *Conn) GetW(path string) (string, zkconn.Stat, <-chan zkconn.Event, error) {
+	data, stat, watch, err := c.conn.GetW(path)
+	if err != nil {
+		return "", nil, nil, fromError("GetW", path, err)
+	}
+	return data, stat, watchChan(watch), nil
+}
+
+func (c *Conn) Children(path string) ([]string, zkconn.Stat, error) {
+	children, stat, err := c.conn.Children(path)
+	if err != nil {
+		return nil, nil, fromError("Children", path, err)
+	}
+	return children, stat, nil
+}
+
+func (c *Conn) ChildrenW(path string) ([]string, zkconn.Stat, <-chan zkconn.Event, error) {
+	children, stat, watch, err := c.conn.ChildrenW(path)
+	if err != nil {
+		return nil, nil, nil, fromError("ChildrenW", path, err)
+	}
+	return children, stat, watchChan(watch), nil
+}
+
+func (c *Conn) Exists(path string) (zkconn.Stat, error) {
+	stat, err := c.conn.Exists(path)
+	if err != nil {
+		return nil, fromError("Exists", path, err)
+	}
+	return stat, nil
+}
+
+func (c *Conn) ExistsW(path string) (zkconn.Stat, <-chan zkconn.Event, error) {
+	stat, watch, err := c.conn.ExistsW(path)
+	if err != nil {
+		return nil, nil, fromError("ExistsW", path, err)
+	}
+	return stat, watchChan(watch), nil
+}
+
+func (c *Conn) Create(path, value string, flags int, aclv []zkconn.ACL) (string, error) {
+	pathCreated, err := c.conn.Create(path, value, flags, toACL(aclv))
+	if err != nil {
+		return "", fromError("Create", path, err)
+	}
+	return pathCreated, nil
+}
+
+func (c *Conn) Set(path, value string, version int) (zkconn.Stat, error) {
+	stat, err := c.conn.Set(path, value, version)
+	if err != nil {
+		return nil, fromError("Set", path, err)
+	}
+	return stat, nil
+}
+
+func (c *Conn) Delete(path string, version int) error {
+	return fromError("Delete", path, c.conn.Delete(path, version))
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) Sync(path string) (string, error) {
+	syncedPath, err := c.conn.Sync(path)
+	if err != nil {
+		return "", fromError("Sync", path, err)
+	}
+	return syncedPath, nil
+}
+
+func (c *Conn) RetryChange(path string, flags int, aclv []zkconn.ACL, changeFunc zkconn.ChangeFunc) error {
+	zkChangeFunc := func(oldValue string, oldStat *zookeeper.Stat) (string, error) {
+		// changeFunc's contract (honored by fakezk and samuelzkconn) is
+		// that a nil zkconn.Stat means the node doesn't exist yet.
+		// Passing oldStat straight through would box a nil *zookeeper.Stat
+		// into a non-nil zkconn.Stat interface value, breaking that
+		// contract and panicking the first time changeFunc calls
+		// oldStat.Version() on it.
+		if oldStat == nil {
+			return changeFunc(oldValue, nil)
+		}
+		return changeFunc(oldValue, oldStat)
+	}
+	return fromError("RetryChange", path, c.conn.RetryChange(path, flags, toACL(aclv), zkChangeFunc))
+}
+
+func (c *Conn) ACL(path string) ([]zkconn.ACL, zkconn.Stat, error) {
+	aclv, stat, err := c.conn.ACL(path)
+	if err != nil {
+		return nil, nil, fromError("ACL", path, err)
+	}
+	return fromACL(aclv), stat, nil
+}
+
+func (c *Conn) SetACL(path string, aclv []zkconn.ACL, version int) error {
+	return fromError("SetACL", path, c.conn.SetACL(path, toACL(aclv), version))
+}
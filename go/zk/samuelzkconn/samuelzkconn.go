@@ -0,0 +1,266 @@
+// Package samuelzkconn implements zk/zkconn.Conn on top of the pure Go
+// github.com/samuel/go-zookeeper/zk client. Unlike zk/gozkconn it needs
+// no C library, so binaries built against it are static and portable to
+// hosts without libzookeeper_mt installed.
+package samuelzkconn
+
+import (
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+
+	"github.com/youtube/vitess/go/zk/zkconn"
+)
+
+// Conn wraps a *zk.Conn to implement zkconn.Conn.
+type Conn struct {
+	conn *zk.Conn
+}
+
+// Dial connects to the ZooKeeper cell described by servers, waiting up
+// to connectTimeout for the initial session event.
+func Dial(servers []string, connectTimeout time.Duration) (*Conn, <-chan zk.Event, error) {
+	conn, session, err := zk.Connect(servers, connectTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Conn{conn: conn}, session, nil
+}
+
+// stat adapts *zk.Stat to the zkconn.Stat interface.
+type stat struct {
+	s *zk.Stat
+}
+
+func (s stat) Czxid() int64          { return s.s.Czxid }
+func (s stat) Mzxid() int64          { return s.s.Mzxid }
+func (s stat) CTime() time.Time      { return millisToTime(s.s.Ctime) }
+func (s stat) MTime() time.Time      { return millisToTime(s.s.Mtime) }
+func (s stat) Version() int          { return int(s.s.Version) }
+func (s stat) CVersion() int         { return int(s.s.Cversion) }
+func (s stat) AVersion() int         { return int(s.s.Aversion) }
+func (s stat) EphemeralOwner() int64 { return s.s.EphemeralOwner }
+func (s stat) DataLength() int       { return int(s.s.DataLength) }
+func (s stat) NumChildren() int      { return int(s.s.NumChildren) }
+func (s stat) Pzxid() int64          { return s.s.Pzxid }
+
+func millisToTime(ms int64) time.Time {
+	return time.Unix(ms/1e3, (ms%1e3)*1e6)
+}
+
+func wrapStat(s *zk.Stat) zkconn.Stat {
+	if s == nil {
+		return nil
+	}
+	return stat{s: s}
+}
+
+func toACL(aclv []zkconn.ACL) []zk.ACL {
+	result := make([]zk.ACL, len(aclv))
+	for i, a := range aclv {
+		result[i] = zk.ACL{Perms: int32(a.Perms), Scheme: a.Scheme, ID: a.ID}
+	}
+	return result
+}
+
+func fromACL(aclv []zk.ACL) []zkconn.ACL {
+	result := make([]zkconn.ACL, len(aclv))
+	for i, a := range aclv {
+		result[i] = zkconn.ACL{Perms: a.Perms, Scheme: a.Scheme, ID: a.ID}
+	}
+	return result
+}
+
+func fromError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch err {
+	case zk.ErrNoNode:
+		return &zkconn.Error{Op: op, Code: zkconn.ErrNoNode, Path: path}
+	case zk.ErrNodeExists:
+		return &zkconn.Error{Op: op, Code: zkconn.ErrNodeExists, Path: path}
+	case zk.ErrNotEmpty:
+		return &zkconn.Error{Op: op, Code: zkconn.ErrNotEmpty, Path: path}
+	case zk.ErrBadVersion:
+		return &zkconn.Error{Op: op, Code: zkconn.ErrBadVersion, Path: path}
+	case zk.ErrSessionExpired:
+		return &zkconn.Error{Op: op, Code: zkconn.ErrSessionExp, Path: path}
+	case zk.ErrConnectionClosed:
+		return &zkconn.Error{Op: op, Code: zkconn.ErrConnectionLoss, Path: path}
+	default:
+		return err
+	}
+}
+
+func fromEventType(t zk.EventType) zkconn.EventType {
+	switch t {
+	case zk.EventNodeCreated:
+		return zkconn.EventNodeCreated
+	case zk.EventNodeDeleted:
+		return zkconn.EventNodeDeleted
+	case zk.EventNodeDataChanged:
+		return zkconn.EventNodeDataChanged
+	case zk.EventNodeChildrenChanged:
+		return zkconn.EventNodeChildrenChanged
+	case zk.EventSession:
+		return zkconn.EventSession
+	default:
+		return zkconn.EventNone
+	}
+}
+
+func fromState(s zk.State) zkconn.StateType {
+	switch s {
+	case zk.StateConnecting:
+		return zkconn.StateConnecting
+	case zk.StateConnected, zk.StateHasSession:
+		return zkconn.StateConnected
+	case zk.StateExpired:
+		return zkconn.StateExpired
+	default:
+		return zkconn.StateUnknown
+	}
+}
+
+func fromEvent(e zk.Event) zkconn.Event {
+	return zkconn.Event{
+		Type:  fromEventType(e.Type),
+		State: fromState(e.State),
+		Path:  e.Path,
+	}
+}
+
+func watchChan(in <-chan zk.Event) <-chan zkconn.Event {
+	out := make(chan zkconn.Event, 1)
+	go func() {
+		out <- fromEvent(<-in)
+		close(out)
+	}()
+	return out
+}
+
+func (c *Conn) Get(path string) (string, zkconn.Stat, error) {
+	data, s, err := c.conn.Get(path)
+	if err != nil {
+		return "", nil, fromError("Get", path, err)
+	}
+	return string(data), wrapStat(s), nil
+}
+
+func (c *Conn) GetW(path string) (string, zkconn.Stat, <-chan zkconn.Event, error) {
+	data, s, watch, err := c.conn.GetW(path)
+	if err != nil {
+		return "", nil, nil, fromError("GetW", path, err)
+	}
+	return string(data), wrapStat(s), watchChan(watch), nil
+}
+
+func (c *Conn) Children(path string) ([]string, zkconn.Stat, error) {
+	children, s, err := c.conn.Children(path)
+	if err != nil {
+		return nil, nil, fromError("Children", path, err)
+	}
+	return children, wrapStat(s), nil
+}
+
+func (c *Conn) ChildrenW(path string) ([]string, zkconn.Stat, <-chan zkconn.Event, error) {
+	children, s, watch, err := c.conn.ChildrenW(path)
+	if err != nil {
+		return nil, nil, nil, fromError("ChildrenW", path, err)
+	}
+	return children, wrapStat(s), watchChan(watch), nil
+}
+
+func (c *Conn) Exists(path string) (zkconn.Stat, error) {
+	ok, s, err := c.conn.Exists(path)
+	if err != nil {
+		return nil, fromError("Exists", path, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return wrapStat(s), nil
+}
+
+func (c *Conn) ExistsW(path string) (zkconn.Stat, <-chan zkconn.Event, error) {
+	ok, s, watch, err := c.conn.ExistsW(path)
+	if err != nil {
+		return nil, nil, fromError("ExistsW", path, err)
+	}
+	if !ok {
+		return nil, watchChan(watch), nil
+	}
+	return wrapStat(s), watchChan(watch), nil
+}
+
+func (c *Conn) Create(path, value string, flags int, aclv []zkconn.ACL) (string, error) {
+	pathCreated, err := c.conn.Create(path, []byte(value), int32(flags), toACL(aclv))
+	if err != nil {
+		return "", fromError("Create", path, err)
+	}
+	return pathCreated, nil
+}
+
+func (c *Conn) Set(path, value string, version int) (zkconn.Stat, error) {
+	s, err := c.conn.Set(path, []byte(value), int32(version))
+	if err != nil {
+		return nil, fromError("Set", path, err)
+	}
+	return wrapStat(s), nil
+}
+
+func (c *Conn) Delete(path string, version int) error {
+	return fromError("Delete", path, c.conn.Delete(path, int32(version)))
+}
+
+func (c *Conn) Close() error {
+	c.conn.Close()
+	return nil
+}
+
+func (c *Conn) Sync(path string) (string, error) {
+	syncedPath, err := c.conn.Sync(path)
+	if err != nil {
+		return "", fromError("Sync", path, err)
+	}
+	return syncedPath, nil
+}
+
+func (c *Conn) RetryChange(path string, flags int, aclv []zkconn.ACL, changeFunc zkconn.ChangeFunc) error {
+	for {
+		oldValue, s, err := c.Get(path)
+		if err != nil && zkconn.Code(err) != zkconn.ErrNoNode {
+			return err
+		}
+		newValue, err := changeFunc(oldValue, s)
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			_, err = c.Create(path, newValue, flags, aclv)
+		} else {
+			_, err = c.Set(path, newValue, s.Version())
+		}
+		if err == nil {
+			return nil
+		}
+		if zkconn.Code(err) != zkconn.ErrBadVersion && zkconn.Code(err) != zkconn.ErrNodeExists {
+			return err
+		}
+		// Lost the race with another writer, retry against the new version.
+	}
+}
+
+func (c *Conn) ACL(path string) ([]zkconn.ACL, zkconn.Stat, error) {
+	aclv, s, err := c.conn.GetACL(path)
+	if err != nil {
+		return nil, nil, fromError("ACL", path, err)
+	}
+	return fromACL(aclv), wrapStat(s), nil
+}
+
+func (c *Conn) SetACL(path string, aclv []zkconn.ACL, version int) error {
+	_, err := c.conn.SetACL(path, toACL(aclv), int32(version))
+	return fromError("SetACL", path, err)
+}
@@ -0,0 +1,150 @@
+// Package zkconn defines the interface Vitess uses to talk to ZooKeeper,
+// independent of any particular client library. Code that only needs to
+// read and write znodes should depend on this package instead of on
+// launchpad.net/gozk/zookeeper or any other ZooKeeper binding directly,
+// so that the backend can be swapped (see zk/gozkconn and
+// zk/samuelzkconn) without touching callers.
+package zkconn
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType mirrors the watch event types a ZooKeeper client can deliver.
+type EventType int
+
+const (
+	EventNone EventType = iota
+	EventNodeCreated
+	EventNodeDeleted
+	EventNodeDataChanged
+	EventNodeChildrenChanged
+	EventSession
+)
+
+// StateType mirrors the connection states a ZooKeeper client can report.
+type StateType int
+
+const (
+	StateUnknown StateType = iota
+	StateConnecting
+	StateConnected
+	StateExpired
+)
+
+// Event is a gozk-free representation of a ZooKeeper watch notification.
+type Event struct {
+	Type  EventType
+	State StateType
+	Path  string
+}
+
+// ErrorCode is a gozk-free representation of a ZooKeeper result code.
+type ErrorCode int
+
+const (
+	ErrOk ErrorCode = 0
+
+	ErrNoNode         ErrorCode = -101
+	ErrNoAuth         ErrorCode = -102
+	ErrBadVersion     ErrorCode = -103
+	ErrNoChildren     ErrorCode = -108
+	ErrNodeExists     ErrorCode = -110
+	ErrNotEmpty       ErrorCode = -111
+	ErrSessionExp     ErrorCode = -112
+	ErrClosing        ErrorCode = -116
+	ErrConnectionLoss ErrorCode = -4
+)
+
+// Error is the gozk-free error type returned by Conn implementations.
+type Error struct {
+	Op   string
+	Code ErrorCode
+	Path string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("zkconn: %v %v: %v", e.Op, e.Path, e.Code)
+}
+
+// Code extracts the ErrorCode from err if it is an *Error,
+// returning ErrOk for a nil error and ErrConnectionLoss for anything else
+// so callers can switch on it without a type assertion.
+func Code(err error) ErrorCode {
+	if err == nil {
+		return ErrOk
+	}
+	if zkErr, ok := err.(*Error); ok {
+		return zkErr.Code
+	}
+	return ErrConnectionLoss
+}
+
+// CreateMode flags, passed as Conn.Create's flags argument. They match
+// the bit values both the gozk and samuel/go-zookeeper clients expect,
+// so callers can compose them without depending on either library.
+const (
+	FlagEphemeral = 1
+	FlagSequence  = 2
+)
+
+// ACL is a gozk-free representation of a ZooKeeper ACL entry.
+type ACL struct {
+	Perms  int32
+	Scheme string
+	ID     string
+}
+
+// Stat exposes the metadata ZooKeeper attaches to every znode.
+type Stat interface {
+	Czxid() int64
+	Mzxid() int64
+	CTime() time.Time
+	MTime() time.Time
+	Version() int
+	CVersion() int
+	AVersion() int
+	EphemeralOwner() int64
+	DataLength() int
+	NumChildren() int
+	Pzxid() int64
+}
+
+// ChangeFunc is applied by Conn.RetryChange until it can be committed
+// without a version conflict.
+type ChangeFunc func(oldValue string, oldStat Stat) (newValue string, err error)
+
+// Conn is the interface Vitess uses to talk to ZooKeeper. It is
+// implemented against the old gozk C bindings by zk/gozkconn and
+// against the pure Go github.com/samuel/go-zookeeper client by
+// zk/samuelzkconn, so callers can pick whichever is available on their
+// target platform.
+type Conn interface {
+	Get(path string) (data string, stat Stat, err error)
+	GetW(path string) (data string, stat Stat, watch <-chan Event, err error)
+
+	Children(path string) (children []string, stat Stat, err error)
+	ChildrenW(path string) (children []string, stat Stat, watch <-chan Event, err error)
+
+	Exists(path string) (stat Stat, err error)
+	ExistsW(path string) (stat Stat, watch <-chan Event, err error)
+
+	Create(path, value string, flags int, aclv []ACL) (pathCreated string, err error)
+	Set(path, value string, version int) (stat Stat, err error)
+	Delete(path string, version int) (err error)
+
+	Close() error
+
+	// Sync flushes path's ZooKeeper session so that a subsequent Get,
+	// Children or Exists on it is guaranteed to reflect every write that
+	// completed before Sync was called, even ones this connection's
+	// cache hasn't been notified about yet via a watch. It returns path
+	// back, mirroring the underlying client libraries' signature.
+	Sync(path string) (string, error)
+
+	RetryChange(path string, flags int, aclv []ACL, changeFunc ChangeFunc) error
+
+	ACL(path string) ([]ACL, Stat, error)
+	SetACL(path string, aclv []ACL, version int) error
+}
@@ -0,0 +1,472 @@
+package pdns
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/youtube/vitess/go/zk/zkconn"
+)
+
+// DNSSEC algorithm numbers, per the IANA DNS Security Algorithm Numbers
+// registry. Only these two are supported, matching what this backend's
+// keys are generated with.
+const (
+	dnssecAlgRSASHA256       = 8
+	dnssecAlgECDSAP256SHA256 = 13
+)
+
+// DNS RR type numbers used while building RRsets to sign.
+const (
+	typeA      = 1
+	typeCNAME  = 5
+	typeSOA    = 6
+	typeSRV    = 33
+	typeRRSIG  = 46
+	typeNSEC   = 47
+	typeDNSKEY = 48
+	typeNSEC3  = 50
+)
+
+var typeNames = map[uint16]string{
+	typeA:      "A",
+	typeCNAME:  "CNAME",
+	typeSOA:    "SOA",
+	typeSRV:    "SRV",
+	typeRRSIG:  "RRSIG",
+	typeNSEC:   "NSEC",
+	typeDNSKEY: "DNSKEY",
+	typeNSEC3:  "NSEC3",
+}
+
+// signingKey is one DNSSEC key pair, either a KSK or a ZSK.
+type signingKey struct {
+	algorithm uint8
+	flags     uint16
+	keyTag    uint16
+	ecdsaPriv *ecdsa.PrivateKey
+	rsaPriv   *rsa.PrivateKey
+}
+
+// dnssecKeyRecord is the on-disk/on-ZK encoding of a signingKey: the
+// private key DER (SEC1 for ECDSA, PKCS1 for RSA), base64-encoded so it
+// survives being stored as a znode's string value.
+type dnssecKeyRecord struct {
+	Algorithm  uint8  `json:"algorithm"`
+	Flags      uint16 `json:"flags"`
+	PrivateKey string `json:"private_key"`
+}
+
+func parseSigningKey(data string) (*signingKey, error) {
+	var rec dnssecKeyRecord
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		return nil, fmt.Errorf("pdns: bad dnssec key: %v", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(rec.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("pdns: bad dnssec key encoding: %v", err)
+	}
+	key := &signingKey{algorithm: rec.Algorithm, flags: rec.Flags}
+	switch rec.Algorithm {
+	case dnssecAlgECDSAP256SHA256:
+		if key.ecdsaPriv, err = x509.ParseECPrivateKey(raw); err != nil {
+			return nil, fmt.Errorf("pdns: bad ECDSA dnssec key: %v", err)
+		}
+	case dnssecAlgRSASHA256:
+		if key.rsaPriv, err = x509.ParsePKCS1PrivateKey(raw); err != nil {
+			return nil, fmt.Errorf("pdns: bad RSA dnssec key: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("pdns: unsupported dnssec algorithm %d", rec.Algorithm)
+	}
+	key.keyTag = keyTag(key.dnskeyRdata())
+	return key, nil
+}
+
+// dnskeyRdata renders the DNSKEY RDATA for key, per RFC 4034 section
+// 2.1 and (for the public key encodings) RFC 3110 (RSA) / RFC 6605
+// (ECDSA P-256).
+func (k *signingKey) dnskeyRdata() []byte {
+	rdata := make([]byte, 4)
+	binary.BigEndian.PutUint16(rdata[0:2], k.flags)
+	rdata[2] = 3 // protocol, always 3
+	rdata[3] = k.algorithm
+	switch k.algorithm {
+	case dnssecAlgECDSAP256SHA256:
+		size := 32
+		pub := make([]byte, 2*size)
+		k.ecdsaPriv.PublicKey.X.FillBytes(pub[:size])
+		k.ecdsaPriv.PublicKey.Y.FillBytes(pub[size:])
+		rdata = append(rdata, pub...)
+	case dnssecAlgRSASHA256:
+		e := big.NewInt(int64(k.rsaPriv.PublicKey.E)).Bytes()
+		if len(e) < 256 {
+			rdata = append(rdata, byte(len(e)))
+		} else {
+			rdata = append(rdata, 0, byte(len(e)>>8), byte(len(e)))
+		}
+		rdata = append(rdata, e...)
+		rdata = append(rdata, k.rsaPriv.PublicKey.N.Bytes()...)
+	}
+	return rdata
+}
+
+// keyTag computes a DNSKEY's key tag per RFC 4034 Appendix B.
+func keyTag(rdata []byte) uint16 {
+	var ac uint32
+	for i, b := range rdata {
+		if i&1 == 1 {
+			ac += uint32(b)
+		} else {
+			ac += uint32(b) << 8
+		}
+	}
+	ac += (ac >> 16) & 0xffff
+	return uint16(ac & 0xffff)
+}
+
+// sign produces a raw signature over data's SHA-256 digest, in the wire
+// format RRSIG expects: 64 bytes of r||s for ECDSA P-256 (RFC 6605),
+// or a PKCS#1 v1.5 signature for RSA (RFC 5702).
+func (k *signingKey) sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	switch k.algorithm {
+	case dnssecAlgECDSAP256SHA256:
+		r, s, err := ecdsa.Sign(rand.Reader, k.ecdsaPriv, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	case dnssecAlgRSASHA256:
+		return rsa.SignPKCS1v15(rand.Reader, k.rsaPriv, crypto.SHA256, digest[:])
+	default:
+		return nil, fmt.Errorf("pdns: unsupported dnssec algorithm %d", k.algorithm)
+	}
+}
+
+// DnssecZone is the signing state for one served zone.
+type DnssecZone struct {
+	zone string
+	ksk  *signingKey
+	zsk  *signingKey
+}
+
+// LoadDnssecZoneFromZk loads a zone's KSK and ZSK from
+// <root>/<zone>/ksk and <root>/<zone>/zsk.
+func LoadDnssecZoneFromZk(zconn zkconn.Conn, root, zone string) (*DnssecZone, error) {
+	kskData, _, err := zconn.Get(root + "/" + zone + "/ksk")
+	if err != nil {
+		return nil, fmt.Errorf("pdns: can't read dnssec ksk for %v: %v", zone, err)
+	}
+	zskData, _, err := zconn.Get(root + "/" + zone + "/zsk")
+	if err != nil {
+		return nil, fmt.Errorf("pdns: can't read dnssec zsk for %v: %v", zone, err)
+	}
+	return newDnssecZone(zone, kskData, zskData)
+}
+
+// LoadDnssecZoneFromDir loads a zone's KSK and ZSK from
+// <dir>/<zone>.ksk and <dir>/<zone>.zsk.
+func LoadDnssecZoneFromDir(dir, zone string) (*DnssecZone, error) {
+	kskData, err := ioutil.ReadFile(filepath.Join(dir, zone+".ksk"))
+	if err != nil {
+		return nil, fmt.Errorf("pdns: can't read dnssec ksk for %v: %v", zone, err)
+	}
+	zskData, err := ioutil.ReadFile(filepath.Join(dir, zone+".zsk"))
+	if err != nil {
+		return nil, fmt.Errorf("pdns: can't read dnssec zsk for %v: %v", zone, err)
+	}
+	return newDnssecZone(zone, string(kskData), string(zskData))
+}
+
+func newDnssecZone(zone, kskData, zskData string) (*DnssecZone, error) {
+	ksk, err := parseSigningKey(kskData)
+	if err != nil {
+		return nil, err
+	}
+	zsk, err := parseSigningKey(zskData)
+	if err != nil {
+		return nil, err
+	}
+	return &DnssecZone{zone: zone, ksk: ksk, zsk: zsk}, nil
+}
+
+// encodeName renders name in DNS wire format, lower-cased (the
+// canonical form RFC 4034 section 6.2 requires for anything covered by
+// a signature).
+func encodeName(name string) []byte {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	var buf []byte
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			buf = append(buf, byte(len(label)))
+			buf = append(buf, []byte(label)...)
+		}
+	}
+	return append(buf, 0)
+}
+
+// rrWire renders one resource record in DNS wire format, for use as
+// input to the canonical RRset a signature covers (RFC 4034 section
+// 6.2 minus compression, which is never used in canonical form).
+func rrWire(owner string, rrtype uint16, ttl uint32, rdata []byte) []byte {
+	buf := encodeName(owner)
+	header := make([]byte, 10)
+	binary.BigEndian.PutUint16(header[0:2], rrtype)
+	binary.BigEndian.PutUint16(header[2:4], 1) // class IN
+	binary.BigEndian.PutUint32(header[4:8], ttl)
+	binary.BigEndian.PutUint16(header[8:10], uint16(len(rdata)))
+	buf = append(buf, header...)
+	return append(buf, rdata...)
+}
+
+// aRdata, cnameRdata and srvRdata render the RDATA PDNS expects to see
+// wrapped in an RRSIG for the record types zkns can produce.
+func aRdata(ipv4 string) []byte {
+	if ip := net.ParseIP(ipv4).To4(); ip != nil {
+		return []byte(ip)
+	}
+	return make([]byte, 4)
+}
+
+func cnameRdata(host string) []byte {
+	return encodeName(host)
+}
+
+func srvRdata(port uint16, target string) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint16(buf[2:4], port)
+	return append(buf, encodeName(target)...)
+}
+
+// soaRdata renders the SOA RDATA matching soaLine's zone parameters.
+func (zr *zknsResolver) soaRdata(serial int64) []byte {
+	buf := encodeName(zr.fqdn)
+	buf = append(buf, encodeName("hostmaster."+zr.fqdn)...)
+	nums := make([]byte, 20)
+	binary.BigEndian.PutUint32(nums[0:4], uint32(serial))
+	binary.BigEndian.PutUint32(nums[4:8], uint32(zr.soaRefresh))
+	binary.BigEndian.PutUint32(nums[8:12], uint32(zr.soaRetry))
+	binary.BigEndian.PutUint32(nums[12:16], uint32(zr.soaExpire))
+	binary.BigEndian.PutUint32(nums[16:20], uint32(zr.soaMinimum))
+	return append(buf, nums...)
+}
+
+// signRRset signs the RRset made up of owner/rrtype/ttl/rdatas with
+// key, returning the presentation-format RRSIG RDATA PDNS expects on a
+// "DATA ... RRSIG ..." line.
+func signRRset(key *signingKey, owner string, rrtype uint16, ttl uint32, rdatas [][]byte, signer string, now time.Time) (string, error) {
+	sorted := append([][]byte(nil), rdatas...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return string(sorted[i]) < string(sorted[j])
+	})
+	var wire []byte
+	for _, rd := range sorted {
+		wire = append(wire, rrWire(owner, rrtype, ttl, rd)...)
+	}
+
+	labels := uint8(0)
+	if trimmed := strings.TrimSuffix(owner, "."); trimmed != "" {
+		labels = uint8(strings.Count(trimmed, ".") + 1)
+	}
+	inception := uint32(now.Add(-1 * time.Hour).Unix())
+	expiration := uint32(now.Add(30 * 24 * time.Hour).Unix())
+
+	prefix := make([]byte, 18)
+	binary.BigEndian.PutUint16(prefix[0:2], rrtype)
+	prefix[2] = key.algorithm
+	prefix[3] = labels
+	binary.BigEndian.PutUint32(prefix[4:8], ttl)
+	binary.BigEndian.PutUint32(prefix[8:12], expiration)
+	binary.BigEndian.PutUint32(prefix[12:16], inception)
+	binary.BigEndian.PutUint16(prefix[16:18], key.keyTag)
+	prefix = append(prefix, encodeName(signer)...)
+
+	sig, err := key.sign(append(append([]byte{}, prefix...), wire...))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%v %d %d %d %d %d %d %v. %v",
+		typeNames[rrtype], key.algorithm, labels, ttl, expiration, inception, key.keyTag,
+		strings.TrimSuffix(signer, "."), base64.StdEncoding.EncodeToString(sig)), nil
+}
+
+// addRdata records one zkns entry's RDATA under the RR type writeEntry
+// would have rendered it as, so answerQuery can sign the RRset it just
+// wrote without re-deriving it from scratch.
+func addRdata(rrsets map[uint16][][]byte, namedPort string, entry vtnsAddr) {
+	switch {
+	case namedPort != "" && len(entry.NamedPortMap) > 0:
+		if port, ok := entry.NamedPortMap[namedPort]; ok {
+			rrsets[typeSRV] = append(rrsets[typeSRV], srvRdata(uint16(port), entry.Host))
+		}
+	case entry.IPv4 != "":
+		rrsets[typeA] = append(rrsets[typeA], aRdata(entry.IPv4))
+	case entry.Host != "":
+		rrsets[typeCNAME] = append(rrsets[typeCNAME], cnameRdata(entry.Host))
+	}
+}
+
+// addAxfrRdata is addRdata's AXFR counterpart: an AXFR has no single
+// query name to key by, since every named port gets its own qname (see
+// writeAxfrEntry), so the RRset map is keyed by owner name as well as RR
+// type.
+func addAxfrRdata(rrsets map[string]map[uint16][][]byte, qname string, entry vtnsAddr) {
+	add := func(owner string, rrtype uint16, rdata []byte) {
+		if rrsets[owner] == nil {
+			rrsets[owner] = make(map[uint16][][]byte)
+		}
+		rrsets[owner][rrtype] = append(rrsets[owner][rrtype], rdata)
+	}
+	switch {
+	case len(entry.NamedPortMap) > 0:
+		names := make([]string, 0, len(entry.NamedPortMap))
+		for name := range entry.NamedPortMap {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(name+"."+qname, typeSRV, srvRdata(uint16(entry.NamedPortMap[name]), entry.Host))
+		}
+	case entry.IPv4 != "":
+		add(qname, typeA, aRdata(entry.IPv4))
+	case entry.Host != "":
+		add(qname, typeCNAME, cnameRdata(entry.Host))
+	}
+}
+
+// isDnssecMetaQuery reports whether qtype is one of the PDNS pipe
+// backend's ABI v3 DNSSEC metadata queries, sent as a "Q" line whose
+// qtype field carries the metadata kind instead of a DNS type.
+func isDnssecMetaQuery(qtype string) bool {
+	return qtype == "getDomainMetadata" || qtype == "getDomainKeys"
+}
+
+// answerDnssecMeta answers a getDomainMetadata/getDomainKeys query.
+// getDomainKeys lists this zone's signing keys as PDNS expects for
+// serving DNSKEY records; getDomainMetadata gets an empty answer for
+// every kind, including "NSEC3PARAM" (this backend never emits NSEC3,
+// only the simplified NSEC denial-of-existence writeNsec produces, so
+// there's no NSEC3 parameter set to report) and "PRESIGNED" (answers are
+// always signed online here, never read back pre-signed from storage).
+// An empty answer tells PDNS to fall back to its own defaults.
+func (s *session) answerDnssecMeta(fields []string, w io.Writer) {
+	zone := s.pd.zr.dnssec
+	if zone != nil && len(fields) > 3 && fields[3] == "getDomainKeys" {
+		for _, key := range []*signingKey{zone.ksk, zone.zsk} {
+			fmt.Fprintf(w, "DATA\t%v\t%v\t1\t%v\n", key.keyTag, key.flags, base64.StdEncoding.EncodeToString(key.dnskeyRdata()))
+		}
+	}
+	fmt.Fprintf(w, "END\n")
+}
+
+// signAndEmit writes the RRSIG DATA line covering owner/rrtype/ttl/rdatas,
+// logging (rather than failing the query) if signing fails.
+func signAndEmit(w io.Writer, zone *DnssecZone, owner string, rrtype uint16, ttl uint32, rdatas [][]byte) {
+	if len(rdatas) == 0 {
+		return
+	}
+	rdata, err := signRRset(zone.zsk, owner, rrtype, ttl, rdatas, zone.zone+".", time.Now())
+	if err != nil {
+		fmt.Fprintf(w, "LOG\tpdns: dnssec: couldn't sign %v %v: %v\n", owner, typeNames[rrtype], err)
+		return
+	}
+	fmt.Fprintf(w, "DATA\t%v\tIN\tRRSIG\t%v\t1\t%v\n", owner, ttl, rdata)
+}
+
+// apexTypes lists the RR types this backend always treats as present at
+// the zone apex, used as the bitmap for every NSEC it emits. This
+// backend doesn't maintain a full ordered NSEC chain (doing so would
+// mean walking and caching the whole zone on every negative answer, not
+// just the synced one ZooKeeper already gives it for free), so denial of
+// existence is necessarily approximate: every negative answer gets an
+// NSEC pointing straight at the apex with the apex's own type set,
+// rather than the owner's true next name and type set. That's enough
+// for a PDNS pipe client to get a structurally valid, signed
+// denial-of-existence record, though not a proof against a resolver
+// that walks the chain end to end. NSEC3 isn't implemented at all: this
+// backend only ever emits NSEC.
+var apexTypes = []uint16{typeSOA, typeNSEC, typeRRSIG}
+
+// nsecBitmap renders the type bitmap of an NSEC RDATA, per RFC 4034
+// section 4.1.2.
+func nsecBitmap(types []uint16) []byte {
+	byWindow := make(map[byte][]uint16)
+	for _, t := range types {
+		w := byte(t >> 8)
+		byWindow[w] = append(byWindow[w], t)
+	}
+	var windows []byte
+	for _, w := range sortedWindows(byWindow) {
+		bits := byWindow[w]
+		maxBit := 0
+		for _, t := range bits {
+			if b := int(t & 0xff); b > maxBit {
+				maxBit = b
+			}
+		}
+		length := maxBit/8 + 1
+		bitmap := make([]byte, length)
+		for _, t := range bits {
+			b := t & 0xff
+			bitmap[b/8] |= 1 << uint(7-b%8)
+		}
+		windows = append(windows, w, byte(length))
+		windows = append(windows, bitmap...)
+	}
+	return windows
+}
+
+func sortedWindows(byWindow map[byte][]uint16) []byte {
+	windows := make([]byte, 0, len(byWindow))
+	for w := range byWindow {
+		windows = append(windows, w)
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i] < windows[j] })
+	return windows
+}
+
+// nsecRdata renders an NSEC RDATA proving owner has no RRs besides
+// types, and that next is the next name in the zone (always the apex;
+// see apexTypes).
+func nsecRdata(next string, types []uint16) []byte {
+	return append(encodeName(next), nsecBitmap(types)...)
+}
+
+// nsecTypesLine renders types as the space-separated type-name list a
+// presentation-format NSEC record's type bitmap field expects, e.g. "SOA
+// NSEC RRSIG".
+func nsecTypesLine(types []uint16) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = typeNames[t]
+	}
+	return strings.Join(names, " ")
+}
+
+// writeNsec answers a negative/empty query for owner with a signed NSEC
+// record, denying the existence of anything at owner beyond apexTypes
+// and pointing at the zone apex as the (approximate) next name; see
+// apexTypes for why this is a simplification rather than a full chain.
+func writeNsec(w io.Writer, zone *DnssecZone, owner, apex string) {
+	fmt.Fprintf(w, "DATA\t%v\tIN\tNSEC\t1\t1\t%v %v\n", owner, apex, nsecTypesLine(apexTypes))
+	signAndEmit(w, zone, owner, typeNSEC, 1, [][]byte{nsecRdata(apex, apexTypes)})
+}
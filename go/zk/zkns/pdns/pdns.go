@@ -0,0 +1,607 @@
+// Package pdns implements a PowerDNS "pipe" backend
+// (https://doc.powerdns.com/authoritative/backends/pipe.html) that
+// answers DNS queries from data stored in ZooKeeper under the zkns
+// naming convention: a znode holds a JSON-encoded list of service
+// endpoints, and its path maps onto a DNS name by stripping the
+// configured root and appending the configured suffix.
+package pdns
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/youtube/vitess/go/zk/zkconn"
+)
+
+// minAbiVersion and maxAbiVersion bound the PDNS pipe backend ABI
+// versions this package can negotiate during HELO.
+const (
+	minAbiVersion = 2
+	maxAbiVersion = 3
+)
+
+// defaultSOA* are the zone parameters used when the resolver isn't
+// given explicit ones, matching what this backend has always shipped.
+const (
+	defaultSOARefresh = 1800
+	defaultSOARetry   = 600
+	defaultSOAExpire  = 3600
+	defaultSOAMinimum = 300
+)
+
+// defaultNegativeTTL bounds how long a ZNONODE answer is served from
+// cache before zknsResolver checks ZooKeeper again. Unlike positive
+// entries, negative ones aren't kept fresh by a watch (there is nothing
+// to watch until the znode is created), so they need a TTL instead.
+const defaultNegativeTTL = 30 * time.Second
+
+// vtnsAddr is one endpoint stored in a zkns znode.
+type vtnsAddr struct {
+	Host         string         `json:"host"`
+	NamedPortMap map[string]int `json:"named_port_map"`
+	IPv4         string         `json:"ipv4"`
+}
+
+// vtnsAddrs is the top level JSON document stored at a zkns znode.
+type vtnsAddrs struct {
+	Entries []vtnsAddr `json:"Entries"`
+}
+
+// cacheEntry is one path's worth of cached zkns data. negative entries
+// (no znode at this path) expire on a TTL; positive ones are kept fresh
+// by a watch and never expire on their own.
+type cacheEntry struct {
+	addrs    *vtnsAddrs
+	mzxid    int64
+	negative bool
+	expires  time.Time
+}
+
+// zknsResolver turns zkns znode paths into DNS answers. It keeps an
+// in-process cache of znode contents so that repeated queries for the
+// same name don't each cost a ZooKeeper round trip; the cache is kept
+// fresh by ZooKeeper watches rather than by polling.
+type zknsResolver struct {
+	zconn  zkconn.Conn
+	fqdn   string
+	suffix string
+	root   string
+
+	negativeTTL time.Duration
+	soaRefresh  int
+	soaRetry    int
+	soaExpire   int
+	soaMinimum  int
+
+	// strict forces every query answered by this resolver onto the slow
+	// path (see get), regardless of the per-query "strict=1" Q-line
+	// field. SetStrict is the per-zone equivalent of that field.
+	strict bool
+
+	// dnssec is non-nil once SetDnssec has loaded a zone's keys, and
+	// causes every answer to be accompanied by RRSIG records.
+	dnssec *DnssecZone
+
+	mu sync.Mutex
+	// cache maps a path to its cacheEntry.
+	cache map[string]*cacheEntry
+	// watching tracks which paths already have a goroutine waiting on an
+	// outstanding watch to invalidate and refresh their cache entry, so
+	// that fetch doesn't arm a new watch (and leak another goroutine)
+	// every time it's called for a path that's already being watched --
+	// which happens on every query once strict mode is in effect, since
+	// fetch is then called unconditionally instead of only on cache miss.
+	watching map[string]bool
+}
+
+// newZknsResolver creates a resolver that answers queries for names
+// ending in suffix by reading zkns znodes rooted at root.
+func newZknsResolver(zconn zkconn.Conn, fqdn, suffix, root string) *zknsResolver {
+	return &zknsResolver{
+		zconn:       zconn,
+		fqdn:        fqdn,
+		suffix:      suffix,
+		root:        root,
+		negativeTTL: defaultNegativeTTL,
+		soaRefresh:  defaultSOARefresh,
+		soaRetry:    defaultSOARetry,
+		soaExpire:   defaultSOAExpire,
+		soaMinimum:  defaultSOAMinimum,
+		cache:       make(map[string]*cacheEntry),
+		watching:    make(map[string]bool),
+	}
+}
+
+// SetNegativeTTL overrides how long a ZNONODE answer is cached. It must
+// be called before the resolver serves any query.
+func (zr *zknsResolver) SetNegativeTTL(ttl time.Duration) {
+	zr.negativeTTL = ttl
+}
+
+// SetSOAParams overrides the refresh/retry/expire/minimum values this
+// resolver reports in the zone's SOA record. It must be called before
+// the resolver serves any query.
+func (zr *zknsResolver) SetSOAParams(refresh, retry, expire, minimum int) {
+	zr.soaRefresh, zr.soaRetry, zr.soaExpire, zr.soaMinimum = refresh, retry, expire, minimum
+}
+
+// SetDnssec turns on online DNSSEC signing using zone's keys. It must
+// be called before the resolver serves any query.
+func (zr *zknsResolver) SetDnssec(zone *DnssecZone) {
+	zr.dnssec = zone
+}
+
+// SetStrict forces every query this resolver answers onto the slow,
+// always-synced path (see get), as if every query carried a "strict=1"
+// Q-line field. It must be called before the resolver serves any query.
+func (zr *zknsResolver) SetStrict(strict bool) {
+	zr.strict = strict
+}
+
+// path converts a DNS name like "_http.srv.zkns.test.zk" into the
+// corresponding zkns znode path, e.g. "/zk/test/zkns/srv". Named ports
+// (the "_http" label above) are stripped since they select a field
+// within the entry rather than a znode.
+func (zr *zknsResolver) path(qname string) (path string, namedPort string, ok bool) {
+	if !strings.HasSuffix(qname, zr.suffix) {
+		return "", "", false
+	}
+	trimmed := strings.TrimSuffix(qname, zr.suffix)
+	parts := strings.Split(trimmed, ".")
+	if len(parts) > 0 && strings.HasPrefix(parts[0], "_") {
+		namedPort = parts[0]
+		parts = parts[1:]
+	}
+	// zkns paths read most-significant label first, same as the znode
+	// hierarchy, while qnames read least-significant label first.
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return zr.root + "/" + strings.Join(parts, "/"), namedPort, true
+}
+
+// get reads and decodes the zkns znode for qname. Ordinarily it goes
+// through the resolver's watch-backed cache (the fast path); if strict
+// is set, either by the caller or by SetStrict, it instead syncs with
+// ZooKeeper before reading, to guarantee the answer reflects every write
+// that completed before the query arrived (the slow path). serial is the
+// highest Mzxid seen for the znode, so that callers can report it in the
+// SOA and let slaves tell a fresh answer from a stale one; synced
+// reports which path was actually taken, since that also affects how
+// callers should render serial into the SOA.
+func (zr *zknsResolver) get(qname string, strict bool) (path, namedPort string, addrs *vtnsAddrs, serial int64, synced bool, err error) {
+	path, namedPort, ok := zr.path(qname)
+	if !ok {
+		return "", "", nil, 0, false, fmt.Errorf("pdns: %v doesn't match suffix %v", qname, zr.suffix)
+	}
+	synced = strict || zr.strict
+	if synced {
+		addrs, serial, err = zr.fetchSynced(path)
+	} else {
+		addrs, serial, err = zr.getCached(path)
+	}
+	return path, namedPort, addrs, serial, synced, err
+}
+
+// getCached returns the zkns entries at path, populating the cache from
+// ZooKeeper on a miss and reusing it otherwise.
+func (zr *zknsResolver) getCached(path string) (*vtnsAddrs, int64, error) {
+	zr.mu.Lock()
+	entry, ok := zr.cache[path]
+	zr.mu.Unlock()
+	if ok && (!entry.negative || time.Now().Before(entry.expires)) {
+		return entry.addrs, entry.mzxid, nil
+	}
+	return zr.fetch(path)
+}
+
+// fetch reads path from ZooKeeper, populates the cache, and arranges for
+// a watch (or, for a missing znode, a TTL) to keep the entry fresh.
+func (zr *zknsResolver) fetch(path string) (*vtnsAddrs, int64, error) {
+	data, stat, watch, err := zr.zconn.GetW(path)
+	var entry *cacheEntry
+	switch {
+	case err == nil:
+		addrs := &vtnsAddrs{}
+		if jsonErr := json.Unmarshal([]byte(data), addrs); jsonErr != nil {
+			return nil, 0, fmt.Errorf("pdns: bad zkns data at %v: %v", path, jsonErr)
+		}
+		entry = &cacheEntry{addrs: addrs, mzxid: mzxidOf(stat)}
+		zr.watch(path, path, watch)
+	case zkconn.Code(err) == zkconn.ErrNoNode:
+		// Nothing to Get-watch yet; watch for the znode's creation
+		// instead so the negative entry can be dropped as soon as it
+		// shows up, rather than waiting out the full TTL.
+		_, existsWatch, existsErr := zr.zconn.ExistsW(path)
+		if existsErr == nil {
+			zr.watch(path, path, existsWatch)
+		}
+		entry = &cacheEntry{addrs: &vtnsAddrs{}, negative: true, expires: time.Now().Add(zr.negativeTTL)}
+	default:
+		return nil, 0, err
+	}
+
+	// A ChildrenW watch on the parent catches siblings being added or
+	// removed, which is how a negative answer's parent directory
+	// signals that it's worth re-checking sooner than the TTL. It's
+	// dedup-keyed separately from the GetW/ExistsW watch above: both are
+	// armed for the same path on every fetch, and keying them the same
+	// would make the second watch() call always find the first one
+	// already registered and silently swallow it.
+	parent := parentPath(path)
+	if _, _, childWatch, childErr := zr.zconn.ChildrenW(parent); childErr == nil {
+		zr.watch("children:"+parent, path, childWatch)
+	}
+
+	zr.mu.Lock()
+	zr.cache[path] = entry
+	zr.mu.Unlock()
+	return entry.addrs, entry.mzxid, nil
+}
+
+// fetchSynced forces path onto the slow path: it syncs with ZooKeeper
+// so that the Get, Exists and Children calls fetch makes afterwards are
+// guaranteed to see every write that completed before Sync was called,
+// then always re-fetches (ignoring whatever's cached) so the answer
+// reflects that.
+func (zr *zknsResolver) fetchSynced(path string) (*vtnsAddrs, int64, error) {
+	if _, err := zr.zconn.Sync(path); err != nil && zkconn.Code(err) != zkconn.ErrNoNode {
+		return nil, 0, err
+	}
+	return zr.fetch(path)
+}
+
+// mzxidOf returns stat.Mzxid(), or 0 if stat is nil.
+func mzxidOf(stat zkconn.Stat) int64 {
+	if stat == nil {
+		return 0
+	}
+	return stat.Mzxid()
+}
+
+// watch invalidates path's cache entry and refreshes it in the
+// background as soon as watch fires. It's a no-op if a watch already
+// outstanding under key: fetch is called once per cache miss on the
+// normal path, but unconditionally on every query once strict mode is in
+// effect, and each of those calls would otherwise arm its own watch and
+// leak a goroutine that blocks forever once its watch has fired. key
+// identifies the kind of watch (the path itself for the GetW/ExistsW
+// watch, "children:"+parent for the parent's ChildrenW watch) so that
+// the two kinds, both armed on every fetch of path, dedup independently
+// instead of the second always finding the first already registered.
+func (zr *zknsResolver) watch(key, path string, watch <-chan zkconn.Event) {
+	if watch == nil {
+		return
+	}
+	zr.mu.Lock()
+	if zr.watching[key] {
+		zr.mu.Unlock()
+		return
+	}
+	zr.watching[key] = true
+	zr.mu.Unlock()
+
+	go func() {
+		<-watch
+		zr.mu.Lock()
+		delete(zr.cache, path)
+		delete(zr.watching, key)
+		zr.mu.Unlock()
+		zr.fetch(path)
+	}()
+}
+
+// parentPath returns the ZooKeeper parent directory of p, e.g.
+// "/zk/test/zkns" for "/zk/test/zkns/srv".
+func parentPath(p string) string {
+	if idx := strings.LastIndex(p, "/"); idx > 0 {
+		return p[:idx]
+	}
+	return "/"
+}
+
+// axfrRecord is one resource record produced while walking the zkns
+// tree for AXFR.
+type axfrRecord struct {
+	qname string
+	entry vtnsAddr
+}
+
+// qnameForPath is the inverse of path: it turns a zkns znode path back
+// into the DNS name that would resolve to it.
+func (zr *zknsResolver) qnameForPath(path string) string {
+	rest := strings.TrimPrefix(strings.TrimPrefix(path, zr.root), "/")
+	if rest == "" {
+		return strings.TrimPrefix(zr.suffix, ".")
+	}
+	parts := strings.Split(rest, "/")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, ".") + zr.suffix
+}
+
+// walk reads every zkns entry in the subtree rooted at path, returning
+// them alongside the highest Mzxid seen, for use as the zone serial.
+func (zr *zknsResolver) walk(path string) (records []axfrRecord, maxMzxid int64) {
+	if data, stat, err := zr.zconn.Get(path); err == nil {
+		if mzxid := mzxidOf(stat); mzxid > maxMzxid {
+			maxMzxid = mzxid
+		}
+		addrs := &vtnsAddrs{}
+		if json.Unmarshal([]byte(data), addrs) == nil {
+			qname := zr.qnameForPath(path)
+			for _, entry := range addrs.Entries {
+				records = append(records, axfrRecord{qname: qname, entry: entry})
+			}
+		}
+	}
+
+	children, _, err := zr.zconn.Children(path)
+	if err != nil {
+		return records, maxMzxid
+	}
+	sort.Strings(children)
+	for _, child := range children {
+		childRecords, childMzxid := zr.walk(path + "/" + child)
+		records = append(records, childRecords...)
+		if childMzxid > maxMzxid {
+			maxMzxid = childMzxid
+		}
+	}
+	return records, maxMzxid
+}
+
+// syncedSerial folds a znode's Mzxid and whether the answer it came from
+// took the synced (slow) or cached (fast) path into a single SOA
+// serial: synced answers always report an odd serial, cached ones an
+// even one, so a slave comparing serials over time can tell which path
+// produced each answer without any extra protocol field.
+func syncedSerial(mzxid int64, synced bool) int64 {
+	if synced {
+		return mzxid*2 + 1
+	}
+	return mzxid * 2
+}
+
+// soaLine renders the SOA record PDNS expects to precede every answer.
+// serial is normally syncedSerial of the highest Mzxid among the znodes
+// the answer was built from, so a slave comparing serials can tell a
+// fresher answer from a stale one.
+func (zr *zknsResolver) soaLine(serial int64) string {
+	return fmt.Sprintf("DATA\t%v.\tIN\tSOA\t1\t1\t%v. hostmaster.%v. %v %v %v %v %v\n",
+		zr.suffix, zr.fqdn, zr.fqdn, serial, zr.soaRefresh, zr.soaRetry, zr.soaExpire, zr.soaMinimum)
+}
+
+// pdns drives the PDNS pipe protocol handshake and query loop on top of
+// a zknsResolver.
+type pdns struct {
+	zr *zknsResolver
+}
+
+// NewServer returns a PDNS pipe backend that answers queries ending in
+// suffix from zkns data rooted at root.
+func NewServer(zconn zkconn.Conn, fqdn, suffix, root string) *pdns {
+	return &pdns{zr: newZknsResolver(zconn, fqdn, suffix, root)}
+}
+
+// SetNegativeTTL overrides how long a ZNONODE answer is cached. It must
+// be called before Serve.
+func (pd *pdns) SetNegativeTTL(ttl time.Duration) {
+	pd.zr.SetNegativeTTL(ttl)
+}
+
+// SetSOAParams overrides the refresh/retry/expire/minimum values
+// reported in the zone's SOA record. It must be called before Serve.
+func (pd *pdns) SetSOAParams(refresh, retry, expire, minimum int) {
+	pd.zr.SetSOAParams(refresh, retry, expire, minimum)
+}
+
+// SetDnssec turns on online DNSSEC signing using zone's keys. It must
+// be called before Serve.
+func (pd *pdns) SetDnssec(zone *DnssecZone) {
+	pd.zr.SetDnssec(zone)
+}
+
+// SetStrict forces every query this backend answers onto the slow,
+// always-synced path, as if every query carried a "strict=1" Q-line
+// field. It must be called before Serve.
+func (pd *pdns) SetStrict(strict bool) {
+	pd.zr.SetStrict(strict)
+}
+
+// Serve reads PDNS pipe backend commands from r and writes responses to
+// w until r is exhausted. Each call negotiates its own ABI version, so
+// the same *pdns can serve concurrent connections.
+func (pd *pdns) Serve(r io.Reader, w io.Writer) {
+	reader := bufio.NewReader(r)
+	writer := bufio.NewWriter(w)
+	defer writer.Flush()
+
+	helo, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	abi := 0
+	fmt.Sscanf(strings.TrimRight(helo, "\n"), "HELO\t%d", &abi)
+	if abi < minAbiVersion || abi > maxAbiVersion {
+		fmt.Fprintf(writer, "FAIL\n")
+		return
+	}
+	sess := &session{pd: pd, abi: abi}
+	fmt.Fprintf(writer, "OK\tzkns2pdns\n")
+	writer.Flush()
+
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\n")
+		if line != "" {
+			sess.handleLine(line, writer)
+			writer.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// session holds the state of one PDNS pipe backend connection: just the
+// ABI version negotiated at HELO time.
+type session struct {
+	pd  *pdns
+	abi int
+}
+
+func (s *session) handleLine(line string, w *bufio.Writer) {
+	fields := strings.Split(line, "\t")
+	switch fields[0] {
+	case "Q":
+		if s.abi >= 3 && len(fields) > 3 && isDnssecMetaQuery(fields[3]) {
+			s.answerDnssecMeta(fields, w)
+			return
+		}
+		s.answerQuery(fields, w)
+	case "AXFR":
+		if s.abi >= 3 {
+			s.axfr(fields, w)
+			return
+		}
+		fmt.Fprintf(w, "FAIL\n")
+	case "PING":
+		if s.abi >= 3 {
+			fmt.Fprintf(w, "END\n")
+			return
+		}
+		fmt.Fprintf(w, "FAIL\n")
+	default:
+		fmt.Fprintf(w, "FAIL\n")
+	}
+}
+
+// answerQuery answers a
+// "Q\t<qname>\t<qclass>\t<qtype>\t<id>\t<remote-ip>\t<local-ip>[\t<edns-subnet>[\tstrict=1]]"
+// line. The local-ip, edns-subnet and strict=1 fields only appear from
+// ABI v2 (local-ip), v3 (edns-subnet) and v3 plus strict=1 onwards
+// respectively; edns-subnet doesn't change the answer today, but is
+// accepted so PDNS doesn't see a parse failure. strict=1 is a
+// vitess-specific extension real PDNS never sends: it forces this one
+// query onto the resolver's synced path (see zknsResolver.get), the same
+// way SetStrict forces every query there.
+func (s *session) answerQuery(fields []string, w *bufio.Writer) {
+	if len(fields) < 2 {
+		fmt.Fprintf(w, "FAIL\n")
+		return
+	}
+	qname := fields[1]
+	strict := s.abi >= 3 && len(fields) > 8 && fields[8] == "strict=1"
+
+	_, namedPort, addrs, mzxid, synced, err := s.pd.zr.get(qname, strict)
+	if err != nil {
+		if s.abi >= 3 {
+			fmt.Fprintf(w, "LOG\tpdns: query for %v failed: %v\n", qname, err)
+		} else {
+			// Logging a bad query to the pipe itself would confuse
+			// PDNS ABI v2, which doesn't expect a LOG line before the
+			// corresponding FAIL, so this goes to the regular log.
+			log.Printf("pdns: query for %v failed: %v", qname, err)
+		}
+		fmt.Fprintf(w, "FAIL\n")
+		return
+	}
+
+	serial := syncedSerial(mzxid, synced)
+	io.WriteString(w, s.pd.zr.soaLine(serial))
+	rrsets := make(map[uint16][][]byte)
+	for _, entry := range addrs.Entries {
+		writeEntry(w, qname, namedPort, entry)
+		addRdata(rrsets, namedPort, entry)
+	}
+	if zone := s.pd.zr.dnssec; zone != nil {
+		signAndEmit(w, zone, s.pd.zr.suffix+".", typeSOA, 1, [][]byte{s.pd.zr.soaRdata(serial)})
+		for rrtype, rdatas := range rrsets {
+			signAndEmit(w, zone, qname, rrtype, 1, rdatas)
+		}
+		if len(addrs.Entries) == 0 {
+			writeNsec(w, zone, qname, s.pd.zr.suffix+".")
+		}
+	}
+	fmt.Fprintf(w, "END\n")
+}
+
+// axfr answers an "AXFR\t<zone-id>" request by walking the whole zkns
+// tree this backend serves, per the PDNS pipe backend ABI v3. walk reads
+// straight from ZooKeeper rather than through the cache, so its answer
+// is always synced. If the zone has DNSSEC keys loaded, the SOA is
+// followed by its RRSIG and then by one RRSIG per RRset in the zone,
+// the same way answerQuery signs a single-name answer.
+func (s *session) axfr(fields []string, w *bufio.Writer) {
+	records, maxMzxid := s.pd.zr.walk(s.pd.zr.root)
+	zone := s.pd.zr.dnssec
+	var rrsets map[string]map[uint16][][]byte
+	if zone != nil {
+		rrsets = make(map[string]map[uint16][][]byte)
+	}
+	for _, r := range records {
+		writeAxfrEntry(w, r.qname, r.entry)
+		if zone != nil {
+			addAxfrRdata(rrsets, r.qname, r.entry)
+		}
+	}
+
+	serial := syncedSerial(maxMzxid, true)
+	io.WriteString(w, s.pd.zr.soaLine(serial))
+	if zone != nil {
+		signAndEmit(w, zone, s.pd.zr.suffix+".", typeSOA, 1, [][]byte{s.pd.zr.soaRdata(serial)})
+		for owner, byType := range rrsets {
+			for rrtype, rdatas := range byType {
+				signAndEmit(w, zone, owner, rrtype, 1, rdatas)
+			}
+		}
+	}
+	fmt.Fprintf(w, "END\n")
+}
+
+// writeEntry writes the DATA line(s) for one zkns entry, choosing the
+// record type from what the entry actually contains.
+func writeEntry(w *bufio.Writer, qname, namedPort string, entry vtnsAddr) {
+	switch {
+	case namedPort != "" && len(entry.NamedPortMap) > 0:
+		if port, ok := entry.NamedPortMap[namedPort]; ok {
+			fmt.Fprintf(w, "DATA\t%v\tIN\tSRV\t1\t1\t0\t0 %v %v\n", qname, port, entry.Host)
+		}
+	case entry.IPv4 != "":
+		fmt.Fprintf(w, "DATA\t%v\tIN\tA\t1\t1\t%v\n", qname, entry.IPv4)
+	case entry.Host != "":
+		fmt.Fprintf(w, "DATA\t%v\tIN\tCNAME\t1\t1\t%v\n", qname, entry.Host)
+	}
+}
+
+// writeAxfrEntry is writeEntry's AXFR counterpart: since there is no
+// single query name selecting one named port, every named port in the
+// entry gets its own SRV record.
+func writeAxfrEntry(w *bufio.Writer, qname string, entry vtnsAddr) {
+	if len(entry.NamedPortMap) > 0 {
+		names := make([]string, 0, len(entry.NamedPortMap))
+		for name := range entry.NamedPortMap {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "DATA\t%v.%v\tIN\tSRV\t1\t1\t0\t0 %v %v\n", name, qname, entry.NamedPortMap[name], entry.Host)
+		}
+		return
+	}
+	switch {
+	case entry.IPv4 != "":
+		fmt.Fprintf(w, "DATA\t%v\tIN\tA\t1\t1\t%v\n", qname, entry.IPv4)
+	case entry.Host != "":
+		fmt.Fprintf(w, "DATA\t%v\tIN\tCNAME\t1\t1\t%v\n", qname, entry.Host)
+	}
+}
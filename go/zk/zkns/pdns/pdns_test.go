@@ -1,15 +1,26 @@
 package pdns
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/youtube/vitess/go/netutil"
-	"github.com/youtube/vitess/go/zk"
-	"launchpad.net/gozk/zookeeper"
+	"github.com/youtube/vitess/go/zk/fakezk"
 )
 
 const (
@@ -42,11 +53,11 @@ const (
 
 var fqdn = netutil.FullyQualifiedHostnameOrPanic()
 
-var zconn = &TestZkConn{map[string]string{
+var zconn = fakezk.NewConn(map[string]string{
 	"/zk/test/zkns/srv":   fakeSRV,
 	"/zk/test/zkns/cname": fakeCNAME,
 	"/zk/test/zkns/a":     fakeA,
-}}
+})
 
 var queries = []string{
 	"Q\t_http.srv.zkns.test.zk\tIN\tANY\t-1\t1.1.1.1\t1.1.1.2",
@@ -57,12 +68,30 @@ var queries = []string{
 	"Q\tbad.domain.test.ignore.console.log.errors\tIN\tANY\t-1\t1.1.1.1\t1.1.1.2",
 }
 
-var testSOA = "DATA\t.zkns.test.zk.\tIN\tSOA\t1\t1\t" + fqdn + ". hostmaster." + fqdn + ". 0 1800 600 3600 300\n"
+// mzxidAt returns the Mzxid fakezk assigned path when zconn was seeded,
+// or 0 if path doesn't exist, so the expected SOA serials below don't
+// have to hardcode fakezk's zxid allocation scheme.
+func mzxidAt(path string) int64 {
+	if _, stat, err := zconn.Get(path); err == nil {
+		return stat.Mzxid()
+	}
+	return 0
+}
+
+func soaLine(serial int64) string {
+	return fmt.Sprintf("DATA\t.zkns.test.zk.\tIN\tSOA\t1\t1\t%v. hostmaster.%v. %v 1800 600 3600 300\n", fqdn, fqdn, serial)
+}
+
+// testSOA is what the AXFR test expects: the whole zkns tree's highest
+// Mzxid, which is srv's since it's seeded last. AXFR always walks
+// ZooKeeper directly rather than through the cache, so its answer is
+// always synced.
+var testSOA = soaLine(syncedSerial(mzxidAt("/zk/test/zkns/srv"), true))
 var results = []string{
-	"OK\tzkns2pdns\n" + testSOA + "DATA\t_http.srv.zkns.test.zk\tIN\tSRV\t1\t1\t0\t0 8080 test1\nDATA\t_http.srv.zkns.test.zk\tIN\tSRV\t1\t1\t0\t0 8080 test2\nEND\n",
-	"OK\tzkns2pdns\n" + testSOA + "DATA\ta.zkns.test.zk\tIN\tA\t1\t1\t0.0.0.1\nEND\n",
-	"OK\tzkns2pdns\n" + testSOA + "DATA\tcname.zkns.test.zk\tIN\tCNAME\t1\t1\ttest1\nEND\n",
-	"OK\tzkns2pdns\n" + testSOA + "END\n",
+	"OK\tzkns2pdns\n" + soaLine(syncedSerial(mzxidAt("/zk/test/zkns/srv"), false)) + "DATA\t_http.srv.zkns.test.zk\tIN\tSRV\t1\t1\t0\t0 8080 test1\nDATA\t_http.srv.zkns.test.zk\tIN\tSRV\t1\t1\t0\t0 8080 test2\nEND\n",
+	"OK\tzkns2pdns\n" + soaLine(syncedSerial(mzxidAt("/zk/test/zkns/a"), false)) + "DATA\ta.zkns.test.zk\tIN\tA\t1\t1\t0.0.0.1\nEND\n",
+	"OK\tzkns2pdns\n" + soaLine(syncedSerial(mzxidAt("/zk/test/zkns/cname"), false)) + "DATA\tcname.zkns.test.zk\tIN\tCNAME\t1\t1\ttest1\nEND\n",
+	"OK\tzkns2pdns\n" + soaLine(syncedSerial(0, false)) + "END\n",
 	"OK\tzkns2pdns\nFAIL\n",
 }
 
@@ -123,147 +152,424 @@ func TestQueries(t *testing.T) {
 	}
 }
 
-// FIXME(msolomon) move to zk/fake package
-type TestZkConn struct {
-	data map[string]string
+// runSession feeds lines (already HELO-prefixed by the caller) to a
+// fresh pdns connection over the default test fixtures and returns
+// everything it wrote back.
+func runSession(t *testing.T, lines ...string) string {
+	zr1 := newZknsResolver(zconn, fqdn, ".zkns.test.zk", "/zk/test/zkns")
+	return runSessionOnServer(t, &pdns{zr1}, lines...)
 }
 
-type ZkStat struct {
-	czxid          int64     `bson:"Czxid"`
-	mzxid          int64     `bson:"Mzxid"`
-	cTime          time.Time `bson:"CTime"`
-	mTime          time.Time `bson:"MTime"`
-	version        int       `bson:"Version"`
-	cVersion       int       `bson:"CVersion"`
-	aVersion       int       `bson:"AVersion"`
-	ephemeralOwner int64     `bson:"EphemeralOwner"`
-	dataLength     int       `bson:"DataLength"`
-	numChildren    int       `bson:"NumChildren"`
-	pzxid          int64     `bson:"Pzxid"`
-}
+// runSessionOnServer is runSession against a caller-provided *pdns, for
+// tests that need to configure the resolver (e.g. with SetDnssec)
+// before serving.
+func runSessionOnServer(t *testing.T, pd *pdns, lines ...string) string {
+	inpr, inpw, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe failed: %v", err)
+	}
+	defer inpr.Close()
+	outpr, outpw, err := os.Pipe()
+	if err != nil {
+		inpw.Close()
+		t.Fatalf("pipe failed: %v", err)
+	}
+	defer outpr.Close()
 
-type ZkPath struct {
-	Path string
-}
+	sync := make(chan struct{})
+	go func() {
+		pd.Serve(inpr, outpw)
+		outpw.Close()
+		close(sync)
+	}()
 
-type ZkPathV struct {
-	Paths []string
-}
+	for _, line := range lines {
+		if _, err := io.WriteString(inpw, line); err != nil {
+			inpw.Close()
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	inpw.Close()
 
-type ZkNode struct {
-	Path     string
-	Data     string
-	Stat     ZkStat
-	Children []string
+	data, err := ioutil.ReadAll(outpr)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	<-sync
+	return string(data)
+}
+
+// TestAbiV3PingAndAxfr checks the ABI v3 additions: PING just
+// acknowledges, and AXFR walks the whole zkns tree, DATA lines first,
+// then the zone SOA, then END.
+func TestAbiV3PingAndAxfr(t *testing.T) {
+	got := runSession(t, "HELO\t3\n", "PING\n", "AXFR\t1")
+	want := "OK\tzkns2pdns\n" +
+		"END\n" +
+		"DATA\ta.zkns.test.zk\tIN\tA\t1\t1\t0.0.0.1\n" +
+		"DATA\tcname.zkns.test.zk\tIN\tCNAME\t1\t1\ttest1\n" +
+		"DATA\t_http.srv.zkns.test.zk\tIN\tSRV\t1\t1\t0\t0 8080 test1\n" +
+		"DATA\t_http.srv.zkns.test.zk\tIN\tSRV\t1\t1\t0\t0 8080 test2\n" +
+		testSOA +
+		"END\n"
+	if got != want {
+		t.Fatalf("AXFR session mismatch:\n%#v\nexpected:\n%#v", got, want)
+	}
 }
 
-type ZkNodeV struct {
-	Nodes []*ZkNode
+// TestAbiV3LogsBadDomain checks that, unlike ABI v2, ABI v3 reports a
+// bad query to PDNS itself via a LOG line rather than only the process
+// log.
+func TestAbiV3LogsBadDomain(t *testing.T) {
+	got := runSession(t, "HELO\t3\n", "Q\tbad.domain.test.ignore.console.log.errors\tIN\tANY\t-1\t1.1.1.1\t1.1.1.2")
+	if !strings.HasPrefix(got, "OK\tzkns2pdns\nLOG\t") || !strings.HasSuffix(got, "\nFAIL\n") {
+		t.Fatalf("expected a LOG line ahead of FAIL, got %#v", got)
+	}
 }
 
-// ZkStat methods to match zk.Stat interface
-func (zkStat *ZkStat) Czxid() int64 {
-	return zkStat.czxid
-}
+// TestCacheServesStaleDataUntilWatchFires checks that a cached resolver
+// keeps answering from its cache, and only picks up a ZooKeeper write
+// once the watch it installed actually fires - not on a timer.
+func TestCacheServesStaleDataUntilWatchFires(t *testing.T) {
+	fake := fakezk.NewConn(map[string]string{
+		"/zk/test/zkns/watched": fakeA,
+	})
+	zr := newZknsResolver(fake, fqdn, ".zkns.test.zk", "/zk/test/zkns")
 
-func (zkStat *ZkStat) Mzxid() int64 {
-	return zkStat.mzxid
-}
+	if _, _, addrs, _, _, err := zr.get("watched.zkns.test.zk", false); err != nil || addrs.Entries[0].IPv4 != "0.0.0.1" {
+		t.Fatalf("unexpected initial answer: %#v, %v", addrs, err)
+	}
 
-func (zkStat *ZkStat) CTime() time.Time {
-	return zkStat.cTime
-}
+	updated := `{"Entries": [{"ipv4": "0.0.0.2"}]}`
+	if _, err := fake.Set("/zk/test/zkns/watched", updated, 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
 
-func (zkStat *ZkStat) MTime() time.Time {
-	return zkStat.mTime
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, _, addrs, _, _, err := zr.get("watched.zkns.test.zk", false)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		if addrs.Entries[0].IPv4 == "0.0.0.2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cache never observed the watched write")
+		}
+	}
 }
 
-func (zkStat *ZkStat) Version() int {
-	return zkStat.version
-}
+// TestCacheObservesNodeCreation checks that a negative cache entry is
+// invalidated as soon as the missing znode is created, rather than
+// waiting out the negative TTL.
+func TestCacheObservesNodeCreation(t *testing.T) {
+	fake := fakezk.NewConn(map[string]string{"/zk/test/zkns": ""})
+	zr := newZknsResolver(fake, fqdn, ".zkns.test.zk", "/zk/test/zkns")
+	zr.SetNegativeTTL(time.Hour)
 
-func (zkStat *ZkStat) CVersion() int {
-	return zkStat.cVersion
-}
+	if _, _, addrs, _, _, err := zr.get("created.zkns.test.zk", false); err != nil || len(addrs.Entries) != 0 {
+		t.Fatalf("expected an empty negative answer, got %#v, %v", addrs, err)
+	}
 
-func (zkStat *ZkStat) AVersion() int {
-	return zkStat.aVersion
-}
+	if _, err := fake.Create("/zk/test/zkns/created", fakeA, 0, nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
 
-func (zkStat *ZkStat) EphemeralOwner() int64 {
-	return zkStat.ephemeralOwner
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, _, addrs, _, _, err := zr.get("created.zkns.test.zk", false)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		if len(addrs.Entries) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cache never observed the node creation")
+		}
+	}
 }
 
-func (zkStat *ZkStat) DataLength() int {
-	return zkStat.dataLength
-}
+// TestCacheObservesSiblingCreation checks that creating an unrelated
+// sibling under a negative answer's parent directory invalidates that
+// negative cache entry via the parent's ChildrenW watch, rather than
+// only dropping it once the negative TTL (set far in the future here)
+// expires.
+func TestCacheObservesSiblingCreation(t *testing.T) {
+	fake := fakezk.NewConn(map[string]string{"/zk/test/zkns": ""})
+	zr := newZknsResolver(fake, fqdn, ".zkns.test.zk", "/zk/test/zkns")
+	zr.SetNegativeTTL(time.Hour)
 
-func (zkStat *ZkStat) NumChildren() int {
-	return zkStat.numChildren
-}
+	if _, _, addrs, _, _, err := zr.get("missing.zkns.test.zk", false); err != nil || len(addrs.Entries) != 0 {
+		t.Fatalf("expected an empty negative answer, got %#v, %v", addrs, err)
+	}
+	zr.mu.Lock()
+	before := zr.cache["/zk/test/zkns/missing"]
+	zr.mu.Unlock()
 
-func (zkStat *ZkStat) Pzxid() int64 {
-	return zkStat.pzxid
-}
+	if _, err := fake.Create("/zk/test/zkns/sibling", fakeA, 0, nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
 
-func (conn *TestZkConn) Get(path string) (data string, stat zk.Stat, err error) {
-	data, ok := conn.data[path]
-	if !ok {
-		err = &zookeeper.Error{Op: "TestZkConn: node doesn't exist", Code: zookeeper.ZNONODE, Path: path}
-		return
+	deadline := time.Now().Add(time.Second)
+	for {
+		zr.mu.Lock()
+		after := zr.cache["/zk/test/zkns/missing"]
+		zr.mu.Unlock()
+		if after != before {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sibling creation never invalidated the negative cache entry")
+		}
 	}
-	s := &ZkStat{}
-	return data, s, nil
 }
 
-func (conn *TestZkConn) GetW(path string) (data string, stat zk.Stat, watch <-chan zookeeper.Event, err error) {
-	panic("Should not be used")
-}
+// TestStrictQueryForcesSync checks that a query carrying the
+// vitess-only "strict=1" Q-line field is reported with a synced (odd)
+// SOA serial, unlike an ordinary query's cached (even) one.
+func TestStrictQueryForcesSync(t *testing.T) {
+	zr1 := newZknsResolver(zconn, fqdn, ".zkns.test.zk", "/zk/test/zkns")
+	got := runSessionOnServer(t, &pdns{zr1}, "HELO\t3\n", "Q\ta.zkns.test.zk\tIN\tANY\t-1\t1.1.1.1\t1.1.1.2\t0\tstrict=1")
 
-func (conn *TestZkConn) Children(path string) (children []string, stat zk.Stat, err error) {
-	panic("Should not be used")
+	var soaText string
+	for _, line := range strings.Split(got, "\n") {
+		if strings.HasPrefix(line, "DATA\t.zkns.test.zk.\tIN\tSOA\t") {
+			soaText = line
+		}
+	}
+	if soaText == "" {
+		t.Fatalf("no SOA line in %#v", got)
+	}
+	serial, err := strconv.ParseInt(strings.Fields(strings.Split(soaText, "\t")[6])[2], 10, 64)
+	if err != nil {
+		t.Fatalf("bad SOA serial in %#v: %v", soaText, err)
+	}
+	if serial%2 == 0 {
+		t.Fatalf("strict query reported an even (cached) serial %d, want odd (synced)", serial)
+	}
 }
 
-func (conn *TestZkConn) ChildrenW(path string) (children []string, stat zk.Stat, watch <-chan zookeeper.Event, err error) {
-	panic("Should not be used")
+// TestFetchSyncedReusesOutstandingWatch checks that repeated strict
+// fetches for the same path don't each arm their own watch: fetchSynced
+// is called unconditionally on every strict query, so without dedup
+// every one of those calls would spawn a fresh goroutine that blocks
+// forever waiting on its own watch channel. Each fetch arms two kinds of
+// watch (the path's own GetW watch and its parent's ChildrenW watch), so
+// the outstanding count settles at 2, not 1.
+func TestFetchSyncedReusesOutstandingWatch(t *testing.T) {
+	zr := newZknsResolver(zconn, fqdn, ".zkns.test.zk", "/zk/test/zkns")
+	path := "/zk/test/zkns/srv"
+	for i := 0; i < 5; i++ {
+		if _, _, err := zr.fetchSynced(path); err != nil {
+			t.Fatalf("fetchSynced(%d): %v", i, err)
+		}
+	}
+	zr.mu.Lock()
+	n := len(zr.watching)
+	zr.mu.Unlock()
+	if n != 2 {
+		t.Fatalf("got %d outstanding watches after 5 strict fetches of the same path, want 2", n)
+	}
 }
 
-func (conn *TestZkConn) Exists(path string) (stat zk.Stat, err error) {
-	_, ok := conn.data[path]
-	if ok {
-		return &ZkStat{}, nil
+// newTestDnssecZone builds a DnssecZone (using the same ECDSA key as
+// both KSK and ZSK) for tests that need one to sign with.
+func newTestDnssecZone(t *testing.T, zone string) (*DnssecZone, *ecdsa.PrivateKey) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey failed: %v", err)
+	}
+	keyData, err := json.Marshal(dnssecKeyRecord{
+		Algorithm:  dnssecAlgECDSAP256SHA256,
+		Flags:      256,
+		PrivateKey: base64.StdEncoding.EncodeToString(der),
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	dz, err := newDnssecZone(zone, string(keyData), string(keyData))
+	if err != nil {
+		t.Fatalf("newDnssecZone failed: %v", err)
+	}
+	return dz, priv
+}
+
+// verifyRRSIG parses rrsigLine's RDATA field and checks that it
+// validates, against priv, as a signature over the canonical RRset built
+// from owner/rrtype/rdata. It returns the RRSIG's key tag, for the
+// caller to cross-check against the key that should have signed it.
+func verifyRRSIG(t *testing.T, priv *ecdsa.PrivateKey, rrsigLine, owner string, rrtype uint16, rdata []byte) int {
+	t.Helper()
+	fields := strings.Split(rrsigLine, "\t")
+	rr := strings.Fields(fields[6])
+	if len(rr) != 9 || rr[0] != typeNames[rrtype] {
+		t.Fatalf("unexpected RRSIG rdata %#v", fields[6])
+	}
+	algorithm, _ := strconv.Atoi(rr[1])
+	labels, _ := strconv.Atoi(rr[2])
+	ttl, _ := strconv.ParseUint(rr[3], 10, 32)
+	expiration, _ := strconv.ParseUint(rr[4], 10, 32)
+	inception, _ := strconv.ParseUint(rr[5], 10, 32)
+	keyTagGot, _ := strconv.Atoi(rr[6])
+	signer := rr[7]
+	sig, err := base64.StdEncoding.DecodeString(rr[8])
+	if err != nil {
+		t.Fatalf("bad signature encoding: %v", err)
+	}
+	if algorithm != dnssecAlgECDSAP256SHA256 {
+		t.Fatalf("unexpected algorithm %d", algorithm)
 	}
-	return nil, nil
-}
 
-func (conn *TestZkConn) ExistsW(path string) (stat zk.Stat, watch <-chan zookeeper.Event, err error) {
-	panic("Should not be used")
+	prefix := make([]byte, 18)
+	binary.BigEndian.PutUint16(prefix[0:2], rrtype)
+	prefix[2] = byte(algorithm)
+	prefix[3] = byte(labels)
+	binary.BigEndian.PutUint32(prefix[4:8], uint32(ttl))
+	binary.BigEndian.PutUint32(prefix[8:12], uint32(expiration))
+	binary.BigEndian.PutUint32(prefix[12:16], uint32(inception))
+	binary.BigEndian.PutUint16(prefix[16:18], uint16(keyTagGot))
+	prefix = append(prefix, encodeName(signer)...)
+	wire := rrWire(owner, rrtype, uint32(ttl), rdata)
+	digest := sha256.Sum256(append(prefix, wire...))
+
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&priv.PublicKey, digest[:], r, s) {
+		t.Fatalf("RRSIG signature for %v %v didn't validate", owner, typeNames[rrtype])
+	}
+	return keyTagGot
 }
 
-func (conn *TestZkConn) Create(path, value string, flags int, aclv []zookeeper.ACL) (pathCreated string, err error) {
-	panic("Should not be used")
-}
+// TestDnssecSignsARecord checks that, once a zone's DNSSEC keys are
+// loaded, a query for an A record comes back with an RRSIG that
+// validates against the zone's public key.
+func TestDnssecSignsARecord(t *testing.T) {
+	zone, priv := newTestDnssecZone(t, "zkns.test.zk")
 
-func (conn *TestZkConn) Set(path, value string, version int) (stat zk.Stat, err error) {
-	panic("Should not be used")
-}
+	zr1 := newZknsResolver(zconn, fqdn, ".zkns.test.zk", "/zk/test/zkns")
+	zr1.SetDnssec(zone)
+	got := runSessionOnServer(t, &pdns{zr1}, "HELO\t3\n", "Q\ta.zkns.test.zk\tIN\tANY\t-1\t1.1.1.1\t1.1.1.2")
+
+	var rrsigLine string
+	for _, line := range strings.Split(got, "\n") {
+		if strings.HasPrefix(line, "DATA\ta.zkns.test.zk\tIN\tRRSIG\t") {
+			rrsigLine = line
+		}
+	}
+	if rrsigLine == "" {
+		t.Fatalf("no RRSIG covering the A record in %#v", got)
+	}
 
-func (conn *TestZkConn) Delete(path string, version int) (err error) {
-	panic("Should not be used")
+	keyTagGot := verifyRRSIG(t, priv, rrsigLine, "a.zkns.test.zk", typeA, aRdata("0.0.0.1"))
+	if keyTagGot != int(zone.zsk.keyTag) {
+		t.Fatalf("key tag mismatch: got %d, want %d", keyTagGot, zone.zsk.keyTag)
+	}
 }
 
-func (conn *TestZkConn) Close() error {
-	panic("Should not be used")
+// TestDnssecSignsAxfr checks that, once a zone's DNSSEC keys are loaded,
+// an AXFR comes back with RRSIGs covering both the zone's SOA and its
+// records that validate against the zone's public key.
+func TestDnssecSignsAxfr(t *testing.T) {
+	zone, priv := newTestDnssecZone(t, "zkns.test.zk")
+
+	zr1 := newZknsResolver(zconn, fqdn, ".zkns.test.zk", "/zk/test/zkns")
+	zr1.SetDnssec(zone)
+	got := runSessionOnServer(t, &pdns{zr1}, "HELO\t3\n", "AXFR\t1")
+
+	var soaLine, soaRRSIG, aRRSIG string
+	for _, line := range strings.Split(got, "\n") {
+		switch {
+		case strings.HasPrefix(line, "DATA\t.zkns.test.zk.\tIN\tSOA\t"):
+			soaLine = line
+		case strings.HasPrefix(line, "DATA\t.zkns.test.zk.\tIN\tRRSIG\t"):
+			soaRRSIG = line
+		case strings.HasPrefix(line, "DATA\ta.zkns.test.zk\tIN\tRRSIG\t"):
+			aRRSIG = line
+		}
+	}
+	if soaLine == "" || soaRRSIG == "" {
+		t.Fatalf("missing SOA or its RRSIG in %#v", got)
+	}
+	if aRRSIG == "" {
+		t.Fatalf("no RRSIG covering the A record in %#v", got)
+	}
+
+	serial, err := strconv.ParseInt(strings.Fields(strings.Split(soaLine, "\t")[6])[2], 10, 64)
+	if err != nil {
+		t.Fatalf("bad SOA serial in %#v: %v", soaLine, err)
+	}
+	verifyRRSIG(t, priv, soaRRSIG, ".zkns.test.zk.", typeSOA, zr1.soaRdata(serial))
+	verifyRRSIG(t, priv, aRRSIG, "a.zkns.test.zk", typeA, aRdata("0.0.0.1"))
 }
 
-func (conn *TestZkConn) RetryChange(path string, flags int, acl []zookeeper.ACL, changeFunc zk.ChangeFunc) error {
-	panic("Should not be used")
+// TestDnssecSignsNsecForEmptyAnswer checks that, once a zone's DNSSEC
+// keys are loaded, a query for a name with no records comes back with a
+// signed NSEC proving so, rather than a bare SOA.
+func TestDnssecSignsNsecForEmptyAnswer(t *testing.T) {
+	zone, priv := newTestDnssecZone(t, "zkns.test.zk")
+
+	zr1 := newZknsResolver(zconn, fqdn, ".zkns.test.zk", "/zk/test/zkns")
+	zr1.SetDnssec(zone)
+	got := runSessionOnServer(t, &pdns{zr1}, "HELO\t3\n", "Q\tempty.zkns.test.zk\tIN\tANY\t-1\t1.1.1.1\t1.1.1.2")
+
+	var nsecLine, nsecRRSIG string
+	for _, line := range strings.Split(got, "\n") {
+		switch {
+		case strings.HasPrefix(line, "DATA\tempty.zkns.test.zk\tIN\tNSEC\t"):
+			nsecLine = line
+		case strings.HasPrefix(line, "DATA\tempty.zkns.test.zk\tIN\tRRSIG\t"):
+			nsecRRSIG = line
+		}
+	}
+	wantNsec := "DATA\tempty.zkns.test.zk\tIN\tNSEC\t1\t1\t.zkns.test.zk. SOA NSEC RRSIG"
+	if nsecLine != wantNsec {
+		t.Fatalf("NSEC line = %#v, want %#v (full answer: %#v)", nsecLine, wantNsec, got)
+	}
+	if nsecRRSIG == "" {
+		t.Fatalf("no RRSIG covering the NSEC in %#v", got)
+	}
+
+	verifyRRSIG(t, priv, nsecRRSIG, "empty.zkns.test.zk", typeNSEC, nsecRdata(".zkns.test.zk.", apexTypes))
 }
 
-func (conn *TestZkConn) ACL(path string) ([]zookeeper.ACL, zk.Stat, error) {
-	panic("Should not be used")
+// TestDnssecGetDomainKeys checks that a getDomainKeys meta query lists
+// both the zone's KSK and ZSK as DNSKEY material.
+func TestDnssecGetDomainKeys(t *testing.T) {
+	zone, _ := newTestDnssecZone(t, "zkns.test.zk")
+
+	zr1 := newZknsResolver(zconn, fqdn, ".zkns.test.zk", "/zk/test/zkns")
+	zr1.SetDnssec(zone)
+	got := runSessionOnServer(t, &pdns{zr1}, "HELO\t3\n", "Q\tzkns.test.zk\tIN\tgetDomainKeys\t-1\t1.1.1.1\t1.1.1.2")
+
+	want := "OK\tzkns2pdns\n" +
+		fmt.Sprintf("DATA\t%v\t%v\t1\t%v\n", zone.ksk.keyTag, zone.ksk.flags, base64.StdEncoding.EncodeToString(zone.ksk.dnskeyRdata())) +
+		fmt.Sprintf("DATA\t%v\t%v\t1\t%v\n", zone.zsk.keyTag, zone.zsk.flags, base64.StdEncoding.EncodeToString(zone.zsk.dnskeyRdata())) +
+		"END\n"
+	if got != want {
+		t.Fatalf("getDomainKeys session mismatch:\n%#v\nexpected:\n%#v", got, want)
+	}
 }
 
-func (conn *TestZkConn) SetACL(path string, aclv []zookeeper.ACL, version int) error {
-	panic("Should not be used")
+// TestDnssecGetDomainMetadataIsEmpty checks that getDomainMetadata always
+// falls back to PDNS's own defaults (an empty answer): this backend
+// signs every answer online rather than keeping metadata like
+// NSEC3PARAM or PRESIGNED in storage.
+func TestDnssecGetDomainMetadataIsEmpty(t *testing.T) {
+	zone, _ := newTestDnssecZone(t, "zkns.test.zk")
+
+	zr1 := newZknsResolver(zconn, fqdn, ".zkns.test.zk", "/zk/test/zkns")
+	zr1.SetDnssec(zone)
+	got := runSessionOnServer(t, &pdns{zr1}, "HELO\t3\n", "Q\tzkns.test.zk\tIN\tgetDomainMetadata\tNSEC3PARAM\t1.1.1.1\t1.1.1.2")
+	want := "OK\tzkns2pdns\nEND\n"
+	if got != want {
+		t.Fatalf("getDomainMetadata session mismatch:\n%#v\nexpected:\n%#v", got, want)
+	}
 }
+